@@ -26,8 +26,6 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"gotodotxt/tdt"
 )
 
 var archiveAliases = []string{"a"}
@@ -39,18 +37,16 @@ var archiveCmd = &cobra.Command{
 
 The tasks will be moved to done.txt.
 If the todo file is called something other than todo.txt,
-the done file will be called filename_done.txt.`,
+the done file will be called filename_done.txt.
+unarchive moves them back.`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		davMode = checkDavMode()
-		opts := tdt.Opts{
-			SortOrder:  sortOrder,
-			ShowFuture: showFuture,
-		}
-		file = tdt.Read(viper.GetString("file"), opts)
-		file.Archive()
-		file.Write()
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
+		checkErr(file.Archive())
 	},
 }
 
@@ -26,8 +26,6 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"gotodotxt/tdt"
 )
 
 var deleteAliases = []string{"del"}
@@ -39,19 +37,18 @@ var deleteCmd = &cobra.Command{
 
 The tasks will be moved to trash.txt.
 If the todo file is called something other than todo.txt,
-the trash file will be called filename_trash.txt.`,
+the trash file will be called filename_trash.txt.
+Each gets a deleted: tag with the date, which purge
+uses, and untrash moves them back.`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		checkIds()
-		davMode = checkDavMode()
-		opts := tdt.Opts{
-			SortOrder:  sortOrder,
-			ShowFuture: showFuture,
-		}
-		file = tdt.Read(viper.GetString("file"), opts)
-		file.Delete(ids...)
-		file.Write()
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
+		checkErr(file.Delete(lineNumbers(file)...))
 	},
 }
 
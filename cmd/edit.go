@@ -27,8 +27,6 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"gotodotxt/tdt"
 )
 
 var (
@@ -47,9 +45,12 @@ var editCmd = &cobra.Command{
 	Short:   "Edit tasks (aliases: " + strings.Join(editAliases, ", ") + ")",
 	Long: `Edit tasks
 
-Due and threshold dates can me specified as a date in the
-form of YYYY-MM-DD. "today", "tomorrow", "monday" (etc.) 
-can also be used.
+Due and threshold dates can be specified as a date in the
+form of YYYY-MM-DD. "today", "tomorrow", "monday" (etc.)
+can also be used, as can "next friday", "in 3 days", "2w",
+"eom" (end of month), "eow", "jan 15" and "15th". A
+threshold can be set relative to the due date, as in
+"due-2d".
 
 For recurrence, a pattern like 1w can be used to set the
 recurrence to one week after the completion date. To
@@ -61,24 +62,36 @@ Priority is just a letter a-z (It will be made uppercase).`,
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		checkIds()
-		davMode = checkDavMode()
-		opts := tdt.Opts{
-			SortOrder:  sortOrder,
-			ShowFuture: showFuture,
-		}
-		file = tdt.Read(viper.GetString("file"), opts)
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
 		if replace != "" {
 			if len(ids) != 1 {
 				return
 			}
-			file.Replace(replace, ids[0])
+			file.Replace(replace, lineNumbers(file)[0])
 		} else {
-			file.Edit(fmt.Sprintf("%s %s %s %s", priority, threshold, due, recurrence), force, ids...)
+			changes := []string{tag("t", threshold), tag("due", due), tag("rec", recurrence)}
+			if priority != "" {
+				changes = append([]string{"(" + strings.ToUpper(strings.Trim(priority, "()")) + ")"}, changes...)
+			}
+			file.Edit(strings.Join(changes, " "), force, lineNumbers(file)...)
 		}
-		file.Write()
+		checkErr(file.Write())
 	},
 }
 
+// tag turns the value of a flag into a key:value tag, joining any words
+// with hyphens so that --due "next friday" becomes due:next-friday.
+func tag(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	value = strings.TrimPrefix(value, key+":")
+	return fmt.Sprintf("%s:%s", key, strings.Join(strings.Fields(value), "-"))
+}
+
 func init() {
 	rootCmd.AddCommand(editCmd)
 
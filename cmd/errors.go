@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gotodotxt/tdt"
+)
+
+// Exit codes.
+const (
+	exitOK int = iota
+	exitError
+	exitNotFound
+	exitPermission
+	exitConflict
+	exitRemoteUnavailable
+	exitParse
+	exitLocked
+)
+
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, tdt.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, tdt.ErrPermission):
+		return exitPermission
+	case errors.Is(err, tdt.ErrConflict):
+		return exitConflict
+	case errors.Is(err, tdt.ErrRemoteUnavailable):
+		return exitRemoteUnavailable
+	case errors.Is(err, tdt.ErrParse):
+		return exitParse
+	case errors.Is(err, tdt.ErrLocked):
+		return exitLocked
+	}
+	return exitError
+}
+
+func checkErr(err error) {
+	if err == nil {
+		return
+	}
+	Log(log.Error, err)
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	var ce *tdt.ConflictError
+	if errors.As(err, &ce) {
+		for _, c := range ce.Conflicts {
+			fmt.Fprintln(os.Stderr, "  base:  ", c.Base)
+			fmt.Fprintln(os.Stderr, "  ours:  ", c.Ours)
+			fmt.Fprintln(os.Stderr, "  theirs:", c.Theirs)
+		}
+	}
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+	"gotodotxt/tdt"
+)
+
+// fileConfig describes where a todo list lives. Entries of "other-files"
+// may be a plain path, which uses the same backend as the main file, or a
+// map with its own backend settings:
+//
+//	other-files:
+//	  - ~/todo/shopping.txt
+//	  - file: Team/todo.txt
+//	    dav-url: https://dav.example.com/
+//	    dav-user: me
+//	    dav-password: secret
+//	  - file: ~/todo/local.txt
+//	    backend: local
+//	    backups: 5
+type fileConfig struct {
+	Path        string
+	Backend     string
+	DavUrl      string
+	DavUser     string
+	DavPassword string
+	TempDir     string
+	Backups     int
+	LockTimeout time.Duration
+}
+
+// mainFile returns the file given by the "file" setting. It is on WebDAV
+// only if all three top-level WebDAV settings are supplied.
+func mainFile() fileConfig {
+	password := viper.GetString("dav-password")
+	if password == "" {
+		password = viper.GetString("dav-pass")
+	}
+	fc := fileConfig{
+		Path:        viper.GetString("file"),
+		Backend:     "local",
+		DavUrl:      viper.GetString("dav-url"),
+		DavUser:     viper.GetString("dav-user"),
+		DavPassword: password,
+		TempDir:     viper.GetString("temp-dir"),
+		Backups:     viper.GetInt("backups"),
+		LockTimeout: viper.GetDuration("lock-timeout"),
+	}
+	if fc.DavUrl != "" && fc.DavUser != "" && fc.DavPassword != "" {
+		fc.Backend = "webdav"
+	}
+	return fc
+}
+
+// otherFiles returns the entries of the "other-files" setting.
+func otherFiles() []fileConfig {
+	var files []fileConfig
+	entries, _ := viper.Get("other-files").([]interface{})
+	for _, e := range entries {
+		fc := mainFile()
+		switch e := e.(type) {
+		case string:
+			fc.Path = e
+		case map[string]interface{}:
+			fc = fileConfig{
+				Path:        configString(e, "file"),
+				Backend:     configString(e, "backend"),
+				DavUrl:      configString(e, "dav-url"),
+				DavUser:     configString(e, "dav-user"),
+				DavPassword: configString(e, "dav-password"),
+				TempDir:     configString(e, "temp-dir"),
+				Backups:     viper.GetInt("backups"),
+				LockTimeout: viper.GetDuration("lock-timeout"),
+			}
+			if n, err := strconv.Atoi(configString(e, "backups")); err == nil {
+				fc.Backups = n
+			}
+			if fc.Backend == "" && fc.DavUrl == "" {
+				fc.Backend = "local"
+			}
+			if fc.TempDir == "" {
+				fc.TempDir = viper.GetString("temp-dir")
+			}
+		default:
+			continue
+		}
+		files = append(files, fc)
+	}
+	return files
+}
+
+func configString(m map[string]interface{}, key string) string {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func (fc fileConfig) storage() (tdt.Storage, error) {
+	backend := fc.Backend
+	if backend == "" {
+		backend = "local"
+		if fc.DavUrl != "" {
+			backend = "webdav"
+		}
+	}
+	switch backend {
+	case "local":
+		return &tdt.LocalStorage{
+			BackupCount: fc.Backups,
+			LockTimeout: fc.LockTimeout,
+		}, nil
+	case "webdav", "dav":
+		if fc.DavUrl == "" || fc.DavUser == "" || fc.DavPassword == "" {
+			return nil, fmt.Errorf("%s: WebDAV needs dav-url, dav-user and dav-password", fc.Path)
+		}
+		s := tdt.NewWebdavStorage(fc.DavUrl, fc.DavUser, fc.DavPassword, fc.TempDir)
+		s.LockTimeout = fc.LockTimeout
+		return s, nil
+	}
+	return nil, fmt.Errorf("%s: unknown backend %q", fc.Path, fc.Backend)
+}
+
+func readFile(fc fileConfig, opts tdt.Opts) (*tdt.TaskFile, error) {
+	s, err := fc.storage()
+	if err != nil {
+		return nil, err
+	}
+	return tdt.ReadFrom(s, fc.Path, opts)
+}
+
+func watchFile(fc fileConfig, opts tdt.Opts) (*tdt.TaskFile, error) {
+	s, err := fc.storage()
+	if err != nil {
+		return nil, err
+	}
+	return tdt.WatchFrom(s, fc.Path, opts)
+}
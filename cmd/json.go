@@ -25,9 +25,9 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"gotodotxt/tdt"
 )
 
@@ -37,10 +37,13 @@ var (
 
 func printJson(tf *tdt.TaskFile) {
 	type output struct {
-		SortOrder  string    `json:"sort_order"`
-		ShowFuture bool      `json:"show_future"`
-		TaskCount  int       `json:"task_count"`
-		Tasks      tdt.Tasks `json:"tasks"`
+		SortOrder  string      `json:"sort_order"`
+		ShowFuture bool        `json:"show_future"`
+		Filter     string      `json:"filter,omitempty"`
+		GroupBy    string      `json:"group_by,omitempty"`
+		TaskCount  int         `json:"task_count"`
+		Tasks      tdt.Tasks   `json:"tasks"`
+		Groups     []tdt.Group `json:"groups,omitempty"`
 	}
 	var filtered tdt.Tasks
 	for _, t := range tf.Tasks {
@@ -51,41 +54,50 @@ func printJson(tf *tdt.TaskFile) {
 	data := output{
 		SortOrder:  tf.Opts.SortOrder,
 		ShowFuture: tf.Opts.ShowFuture,
+		Filter:     tf.Opts.Query.String(),
 		TaskCount:  len(filtered),
 		Tasks:      filtered,
 	}
-	js, err := json.Marshal(data)
-	if err != nil {
-		panic(err)
+	if groups := tf.Groups(); len(groups) > 0 && groups[0].Name != "" {
+		data.GroupBy = tf.Opts.GroupBy
+		data.Groups = groups
 	}
+	js, err := json.Marshal(data)
+	checkErr(err)
 	fmt.Println(string(js))
 }
 
 var jsonAliases = []string{"spoonfeed"}
 var jsonCmd = &cobra.Command{
-	Use:     "json",
+	Use:     "json [filter]",
 	Aliases: jsonAliases,
 	Short:   "Output filtered tasks as JSON",
-	Long:    `Output filtered tasks as JSON`,
+	Long: `Output filtered tasks as JSON
+
+Any arguments are read as a filter expression, and combined
+with --filter if both are given.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		printOnExit = false
-		davMode = checkDavMode()
-		todoFile := viper.GetString("file")
-		opts := tdt.Opts{
-			SortOrder:  sortOrder,
-			ShowFuture: showFuture,
-		}
+		todoFile := mainFile()
+		opts := newOpts()
+		opts.Query = parseFilter(opts.Query.String(), strings.Join(args, " "))
 		if !follow {
-			printJson(tdt.Read(todoFile, opts).Sort().Filter())
+			tf, err := readFile(todoFile, opts)
+			checkErr(err)
+			printJson(listed(tf))
 			return
 		}
-		file = tdt.Watch(todoFile, opts)
-		printJson(file.Sort().Filter())
+		var err error
+		file, err = watchFile(todoFile, opts)
+		checkErr(err)
+		printJson(listed(file))
 		for {
 			select {
-			case <-file.Events:
-				file = tdt.Watch(todoFile, opts)
-				printJson(file.Sort().Filter())
+			case ev := <-file.Events:
+				checkErr(ev.Err)
+				file, err = watchFile(todoFile, opts)
+				checkErr(err)
+				printJson(listed(file))
 			}
 		}
 	},
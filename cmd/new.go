@@ -26,8 +26,6 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"gotodotxt/tdt"
 )
 
 var newAliases = []string{"n", "create", "add"}
@@ -43,14 +41,12 @@ parsed using the standard todo.txt rules.
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		davMode = checkDavMode()
-		opts := tdt.Opts{
-			ShowFuture: showFuture,
-			SortOrder:  sortOrder,
-		}
-		file = tdt.Read(viper.GetString("file"), opts)
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
 		file.Add(strings.Join(args, " "))
-		file.Write()
+		checkErr(file.Write())
 	},
 }
 
@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gookit/color"
 	"gotodotxt/tdt"
@@ -18,6 +18,12 @@ type Row struct {
 	Line1      string
 	Line2      string
 	Lines      int
+	// Group is set on the heading rows of grouped output, which have no
+	// task and a LineNumber of -1.
+	Group string
+	// Source is set on the rows of tasks from the done or trash file,
+	// which can't be selected.
+	Source string
 }
 
 type Rows []Row
@@ -29,6 +35,7 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 	contextCol := color.Green.Render
 	projectCol := color.Magenta.Render
 	dateOkay := color.Green.Render
+	dateToday := color.Yellow.Render
 	dateLate := color.Red.Render
 
 	if t.IsDone() {
@@ -40,6 +47,7 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 		contextCol = baseCol
 		projectCol = baseCol
 		dateOkay = baseCol
+		dateToday = baseCol
 		dateLate = baseCol
 	} else {
 		matches := tdt.ProjectsRegex.FindAllStringSubmatch(t.Description, -1)
@@ -62,7 +70,9 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 
 	// Line 1
 	line1 := ""
-	if drawLineNumber {
+	if drawLineNumber && t.Source != "" {
+		line1 += color.Gray.Render(fmt.Sprintf("%4s ", t.Source[:1]+strconv.Itoa(t.LineNumber)))
+	} else if drawLineNumber {
 		line1 += color.Gray.Render(fmt.Sprintf("%4d ", t.LineNumber))
 	}
 
@@ -72,7 +82,11 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 		line1 += priCol("("+t.Priority+")") + " " + taskCol(t.Description)
 	}
 
-	if !t.HasDue && !t.HasThreshold && t.Recurrence.Period == "" {
+	if t.Source != "" {
+		line1 += " " + color.Gray.Render("["+t.Source+"]")
+	}
+
+	if !t.HasDue && !t.HasThreshold && t.Recurrence.Period == "" && len(t.Tags) == 0 {
 		return line1, ""
 	}
 
@@ -82,8 +96,10 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 		col := dateOkay
 		if t.Overdue {
 			col = dateLate
+		} else if t.DueToday {
+			col = dateToday
 		}
-		line2 += col("due:" + t.Due.Format(dateFormat))
+		line2 += col("due:" + tdt.YMD(t.Due))
 		// line2 += col("due:" + prettytime.Format(t.Due))
 	}
 	if t.HasThreshold {
@@ -91,11 +107,11 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 			line2 += " "
 		}
 		col := dateOkay
-		if t.Threshold.Before(time.Now()) {
+		if !t.IsFuture() {
 			col = dateLate
 		}
 		col = color.Gray.Render
-		line2 += col("t:" + t.Threshold.Format(dateFormat))
+		line2 += col("t:" + tdt.YMD(t.Threshold))
 		// line2 += col("t:" + prettytime.Format(t.Threshold))
 	}
 	if t.Recurrence.Period != "" {
@@ -104,6 +120,12 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 		}
 		line2 += color.Gray.Render("rec:" + t.Recurrence.String)
 	}
+	for _, tag := range t.Tags {
+		if line2 != "" {
+			line2 += " "
+		}
+		line2 += color.Gray.Render(tag.Key + ":" + tag.Value)
+	}
 	line2 = "    " + line2
 	if drawLineNumber {
 		line2 = "     " + line2
@@ -112,33 +134,70 @@ func printTask(t tdt.Task, drawLineNumber bool) (string, string) {
 	return line1, line2
 }
 
+func groupHeading(g tdt.Group) string {
+	return color.Bold.Render(color.Cyan.Render(g.Name)) +
+		color.Gray.Render(fmt.Sprintf(" (%d)", g.TaskCount))
+}
+
 func printTasks(tf *tdt.TaskFile) {
-	for _, t := range tf.Tasks {
-		if t.FilteredOut {
-			continue
+	for i, g := range tf.Groups() {
+		if g.Name != "" {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println("     " + groupHeading(g))
 		}
-		line1, line2 := printTask(t, true)
-		fmt.Println(line1)
-		if line2 != "" {
-			fmt.Println(line2)
+		for _, t := range g.Tasks {
+			line1, line2 := printTask(t, true)
+			fmt.Println(line1)
+			if line2 != "" {
+				fmt.Println(line2)
+			}
 		}
 	}
 	fmt.Println()
-	fmt.Println(color.Gray.Render("         sort: " + sortOrder))
+	fmt.Println(color.Gray.Render("         sort: " + tf.Opts.SortOrder))
+	if tf.Opts.Query != nil {
+		fmt.Println(color.Gray.Render("       filter: " + tf.Opts.Query.String()))
+	}
 }
 
-func renderTasks(tf *tdt.TaskFile, drawLineNumber bool) Rows {
+// renderTasks returns the rows for the tasks of tf, under a heading for
+// each group if they are grouped. The tasks of groups in collapsed are
+// left out.
+func renderTasks(tf *tdt.TaskFile, drawLineNumber bool, collapsed map[string]bool) Rows {
 	var rows []Row
-	for _, t := range tf.Tasks {
-		if t.FilteredOut {
-			continue
+	for _, g := range tf.Groups() {
+		if g.Name != "" {
+			arrow := "▾ "
+			if collapsed[g.Name] {
+				arrow = "▸ "
+			}
+			rows = append(rows, Row{
+				LineNumber: -1,
+				Line1:      "   " + arrow + groupHeading(g),
+				Lines:      1,
+				Group:      g.Name,
+			})
+			if collapsed[g.Name] {
+				continue
+			}
 		}
+		rows = append(rows, renderRows(g.Tasks, drawLineNumber)...)
+	}
+	return rows
+}
+
+func renderRows(tasks tdt.Tasks, drawLineNumber bool) Rows {
+	var rows []Row
+	for _, t := range tasks {
 		line1, line2 := printTask(t, drawLineNumber)
 		r := Row{
 			LineNumber: t.LineNumber,
 			Line1:      line1,
 			Line2:      line2,
 			Lines:      1,
+			Source:     t.Source,
 		}
 		if line2 != "" {
 			r.Lines = 2
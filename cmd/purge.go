@@ -0,0 +1,63 @@
+/*
+Copyright © 2022 Jason Quigley <jason@jasonquigley.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	purgeDays = 30
+)
+
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Remove old tasks from the trash file for good",
+	Long: `Remove old tasks from the trash file for good
+
+Deleted tasks are given a deleted: tag with the date they
+were moved to trash.txt. purge removes those deleted at
+least --days days ago. Tasks deleted before the tag was
+added have no date, and are only removed by --days 0,
+which empties the trash. A purge can be undone.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printOnExit = false
+		if purgeDays < 0 {
+			checkErr(fmt.Errorf("--days must be 0 or more, not %d", purgeDays))
+		}
+		var err error
+		file, err = readFile(mainFile(), newOpts())
+		checkErr(err)
+		n, err := file.Purge(purgeDays)
+		checkErr(err)
+		checkErr(file.Write())
+		fmt.Printf("Purged %d tasks from %s\n", n, file.TrashFile.Path)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+	purgeCmd.Flags().IntVar(&purgeDays, "days", purgeDays, "purge tasks deleted at least this many days ago")
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2022 Jason Quigley <jason@jasonquigley.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gookit/color"
+	"github.com/spf13/cobra"
+	"gotodotxt/tdt"
+)
+
+var (
+	restoreFrom = ""
+)
+
+// restoreTasks moves the tasks picked with --ids from the done or trash
+// file back into the tasks file.
+func restoreTasks(source string) {
+	checkIds()
+	var err error
+	file, err = readFile(mainFile(), newOpts())
+	checkErr(err)
+	nums, err := file.SourceLineNumbers(source, ids...)
+	checkErr(err)
+	checkErr(file.Restore(source, nums...))
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [backup]",
+	Short: "Restore the tasks file from a backup, or tasks from the done or trash file",
+	Long: `Restore the tasks file from a backup
+
+With --from done or --from trash, the tasks picked with
+--ids are moved back from done.txt or trash.txt instead,
+as untrash and unarchive do.
+
+Backups are only kept for local files, and only if the
+backups setting (or --backups flag) is more than zero.
+The todo.txt backups are called todo.txt.1, todo.txt.2
+and so on, most recent first.
+
+Without an argument, the available backups are listed.
+The file being replaced becomes backup 1, so restoring
+can itself be undone by restoring backup 1.`,
+	Args: cobra.MaximumNArgs(1),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if restoreFrom != "" {
+			if len(args) > 0 {
+				checkErr(errors.New("restore takes either a backup or --from, not both"))
+			}
+			restoreTasks(restoreFrom)
+			return
+		}
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
+		bs, ok := file.Storage.(tdt.BackupStorage)
+		if !ok {
+			checkErr(errors.New("backups are only kept of local files"))
+		}
+		if len(args) == 0 {
+			printOnExit = false
+			backups, err := bs.ListBackups(file.Path)
+			checkErr(err)
+			if len(backups) == 0 {
+				fmt.Println("No backups of", file.Path)
+			}
+			for _, b := range backups {
+				fmt.Printf("%4d %s %s\n", b.Number,
+					b.ModTime.Local().Format("2006-01-02 15:04:05"),
+					color.Gray.Render(b.Path))
+			}
+			return
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			checkErr(fmt.Errorf("invalid backup number %q", args[0]))
+		}
+		checkErr(bs.RestoreBackup(file.Path, n))
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
+	},
+}
+
+var untrashCmd = &cobra.Command{
+	Use:   "untrash",
+	Short: "Move deleted task(s) back from the trash file",
+	Long: `Move deleted task(s) back from the trash file
+
+The tasks picked with --ids are moved from trash.txt back
+to the end of the tasks file, without their deleted: tag.
+They are numbered as the trash file's tasks are listed
+with --include trash, as in -i t0 or -i 0, or picked by
+their id tags.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		restoreTasks(tdt.SourceTrash)
+	},
+}
+
+var unarchiveCmd = &cobra.Command{
+	Use:   "unarchive",
+	Short: "Move archived task(s) back from the done file",
+	Long: `Move archived task(s) back from the done file
+
+The tasks picked with --ids are moved from done.txt back
+to the end of the tasks file, still completed. They are
+numbered as the done file's tasks are listed with
+--include done, as in -i d0 or -i 0, or picked by their
+id tags.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		restoreTasks(tdt.SourceDone)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(untrashCmd)
+	rootCmd.AddCommand(unarchiveCmd)
+	restoreCmd.Flags().StringVar(&restoreFrom, "from", "", "move the tasks picked with --ids back from the done or trash file")
+}
@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/cobra"
@@ -44,17 +45,26 @@ var (
 	// todoFile    = "todo.txt"
 	sortOrder   = "done,priority,due-,threshold-"
 	showFuture  = false
+	filterExpr  = ""
+	viewName    = ""
+	groupBy     = ""
+	groupFirst  = false
 	sortFile    = false
 	printOnExit = true
+	autoID      = false
+	backups     = 0
+	lockTimeout = tdt.DefaultLockTimeout
 
 	davUrl      = ""
 	davUser     = ""
 	davPassword = ""
-	davMode     = false
 
 	file *tdt.TaskFile
-	ids  []int
+	ids  []string
 	log  *logging.Logger
+
+	// globalFlags is rootCmd's persistent flag set.
+	globalFlags *pflag.FlagSet
 )
 
 var rootCmd = &cobra.Command{
@@ -69,20 +79,23 @@ environment variable.
 Example:
 TODO_FILE=/some/directory/blah.txt gotodotxt
 
+Tasks are picked with --ids, either by line number or by
+the value of their id: tag. Line numbers change whenever
+the file is edited, so scripts should use id tags. With
+--auto-id (or auto-id: true in the config file), an id tag
+is added to every new task.
+
 If all three webdav related parameters are supplied,
 the program will switch to WebDAV mode. In this mode,
 file update checks are done by polling every 15 seconds.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		davMode = checkDavMode()
 		// fmt.Println(viper.AllKeys())
 		// fmt.Println(">>> ", viper.GetString("file"))
 		// fmt.Println(">>> ", viper.GetString("dav-user"))
-		// fmt.Println(davMode, davUrl, davUser, davPassword)
-		opts := tdt.Opts{
-			ShowFuture: showFuture,
-			SortOrder:  sortOrder,
-		}
-		file = tdt.Read(viper.GetString("file"), opts)
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
 	},
 }
 
@@ -92,8 +105,8 @@ func Execute() {
 	if err != nil {
 		os.Exit(1)
 	}
-	if printOnExit && file != nil && len(file.Tasks) > 0 {
-		printTasks(file.Sort().Filter())
+	if printOnExit && file != nil && (len(file.Tasks) > 0 || len(file.Opts.Include) > 0) {
+		printTasks(listed(file))
 	}
 }
 
@@ -104,26 +117,74 @@ func checkIds() {
 	}
 }
 
-func checkDavMode() bool {
-	return tdt.SetWebdavCredentials(
-		viper.GetString("dav-url"),
-		viper.GetString("dav-user"),
-		viper.GetString("dav-password"),
-		viper.GetString("temp-dir"),
-	)
+// lineNumbers resolves the --ids flag against tf.
+func lineNumbers(tf *tdt.TaskFile) []int {
+	nums, err := tf.LineNumbers(ids...)
+	checkErr(err)
+	return nums
+}
+
+func newOpts() tdt.Opts {
+	n, err := findView(viper.GetString("view"))
+	checkErr(err)
+	opts, err := viewOpts(n)
+	checkErr(err)
+	return opts
+}
+
+// combineFilters joins filter expressions so that all of them must match.
+func combineFilters(exprs ...string) string {
+	var parts []string
+	for _, e := range exprs {
+		if e = strings.TrimSpace(e); e != "" {
+			parts = append(parts, e)
+		}
+	}
+	if len(parts) > 1 {
+		for i, p := range parts {
+			parts[i] = "(" + p + ")"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// listed returns tf with the files named by --include added, sorted and
+// filtered for listing.
+func listed(tf *tdt.TaskFile) *tdt.TaskFile {
+	view, err := tf.WithIncluded()
+	checkErr(err)
+	return view.Sort().Filter()
+}
+
+// parseFilter parses the filter expressions given and ANDs them together.
+func parseFilter(exprs ...string) *tdt.Query {
+	q, err := tdt.ParseQuery(combineFilters(exprs...))
+	checkErr(err)
+	return q
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	globalFlags = rootCmd.PersistentFlags()
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", cfgFile, "config file")
 	rootCmd.PersistentFlags().BoolVarP(&showFuture, "future", "f", false, "show future tasks (default is false)")
 	rootCmd.PersistentFlags().StringVarP(&sortOrder, "sort", "s", sortOrder, "sort order")
-	rootCmd.PersistentFlags().IntSliceVarP(&ids, "ids", "i", nil, "List of task ids")
+	rootCmd.PersistentFlags().StringVarP(&filterExpr, "filter", "q", filterExpr, "only show tasks matching a filter expression")
+	rootCmd.PersistentFlags().StringVar(&viewName, "view", viewName, "use a view from the config file")
+	rootCmd.PersistentFlags().StringVarP(&groupBy, "group-by", "g", groupBy, "group tasks by project, context, priority, due or list")
+	rootCmd.PersistentFlags().BoolVar(&groupFirst, "group-first", groupFirst, "group tasks under their first project or context only")
+	rootCmd.PersistentFlags().StringSlice("include", nil, "also list the tasks in the done or trash file (done, trash or all)")
+	rootCmd.PersistentFlags().StringSliceVarP(&ids, "ids", "i", nil, "List of task ids (line numbers or id tags)")
+	rootCmd.PersistentFlags().BoolVar(&autoID, "auto-id", autoID, "add an id tag to new tasks")
 	rootCmd.PersistentFlags().StringVar(&davUrl, "dav-url", davUrl, "webdav base url")
 	rootCmd.PersistentFlags().StringVar(&davUser, "dav-user", davUser, "webdav user")
 	rootCmd.PersistentFlags().StringVar(&davPassword, "dav-pass", davPassword, "webdav password")
 	rootCmd.PersistentFlags().StringVar(&tempDir, "temp-dir", tempDir, "non-standard temp directory")
+	rootCmd.PersistentFlags().IntVar(&backups, "backups", backups, "number of backups to keep of local files")
+	rootCmd.PersistentFlags().String("timezone", "", "timezone that decides when each day starts, such as Europe/Dublin (default local)")
+	rootCmd.PersistentFlags().Bool("strict", false, "read tasks strictly by the todo.txt format")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", lockTimeout, "how long to wait for another process to release the file")
 }
 
 func initConfig() {
@@ -158,6 +219,11 @@ func initConfig() {
 
 	viperSetWithFlags(rootCmd)
 
+	tdt.Strict = viper.GetBool("strict")
+	if err := tdt.SetLocation(viper.GetString("timezone")); err != nil {
+		checkErr(fmt.Errorf("timezone: %w", err))
+	}
+
 	if viper.GetInt("debug") > 0 {
 		log = GetLogger(viper.GetInt("debug")-1, false)
 		tdt.SetLogger(log)
@@ -0,0 +1,143 @@
+/*
+Copyright © 2022 Jason Quigley <jason@jasonquigley.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/spf13/cobra"
+	"gotodotxt/tdt"
+)
+
+var (
+	statsDays  = 14
+	statsWeeks = 8
+	statsJson  = false
+)
+
+// burndownWidth is the width of the longest bar of the burndown chart.
+const burndownWidth = 30
+
+func printStats(st tdt.Stats) {
+	head := color.Gray.Render
+	title := color.OpBold.Render
+	// count formats n in a column of the given width, in gray if it is 0
+	count := func(n, width int, col func(a ...interface{}) string) string {
+		if n == 0 {
+			col = head
+		}
+		return col(fmt.Sprintf("%*d", width, n))
+	}
+
+	fmt.Println(title("Tasks"))
+	fmt.Printf("  open       %s   overdue %s   due today %s\n", count(st.Open, 4, color.White.Render),
+		count(st.Overdue, 4, color.Red.Render), count(st.DueToday, 4, color.Yellow.Render))
+	fmt.Printf("  completed  %s   %s\n", count(st.Completed, 4, color.Green.Render),
+		head(fmt.Sprintf("(%d archived)", st.Archived)))
+	if st.LeadTimeTasks > 0 {
+		fmt.Printf("  lead time  %6.1f days on average %s\n", st.LeadTime,
+			head(fmt.Sprintf("(%d tasks)", st.LeadTimeTasks)))
+	}
+	fmt.Printf("  rate       %6.1f a day, %.1f a week\n", st.PerDay, st.PerWeek)
+
+	printPeriods := func(name string, periods []tdt.PeriodStats, label func(p tdt.PeriodStats) string) {
+		if len(periods) == 0 {
+			return
+		}
+		most := 1
+		for _, p := range periods {
+			if p.Open > most {
+				most = p.Open
+			}
+		}
+		fmt.Printf("\n%s%s\n", title(fmt.Sprintf("%-19s", name)), head("  created  completed   open"))
+		for _, p := range periods {
+			bar := strings.Repeat("█", (p.Open*burndownWidth+most-1)/most)
+			fmt.Printf("  %-16s %s %s %s  %s\n", label(p), count(p.Created, 9, color.White.Render),
+				count(p.Completed, 10, color.Green.Render), count(p.Open, 6, color.White.Render),
+				color.Cyan.Render(bar))
+		}
+	}
+	printPeriods("Days", st.Days, func(p tdt.PeriodStats) string {
+		d, _ := time.ParseInLocation("2006-01-02", p.Start, tdt.Location)
+		return p.Start + " " + d.Format("Mon")
+	})
+	printPeriods("Weeks", st.Weeks, func(p tdt.PeriodStats) string {
+		return "from " + p.Start
+	})
+
+	printGroups := func(name string, groups []tdt.GroupStats) {
+		if len(groups) == 0 {
+			return
+		}
+		fmt.Printf("\n%s%s\n", title(fmt.Sprintf("%-19s", name)), head("     open  completed  overdue"))
+		for _, g := range groups {
+			fmt.Printf("  %-16s %s %s %s\n", g.Name, count(g.Open, 9, color.White.Render),
+				count(g.Completed, 10, color.Green.Render), count(g.Overdue, 8, color.Red.Render))
+		}
+	}
+	printGroups("Projects", st.Projects)
+	printGroups("Contexts", st.Contexts)
+}
+
+var statsAliases = []string{"report"}
+var statsCmd = &cobra.Command{
+	Use:     "stats",
+	Aliases: statsAliases,
+	Short:   "Show statistics for the tasks and the done file (aliases: " + strings.Join(statsAliases, ", ") + ")",
+	Long: `Show statistics for the tasks and the done file
+
+The counts of open, completed, overdue and due tasks are
+followed by the average lead time from creation to completion,
+the tasks created and completed each day and week with a
+burndown of the tasks left open, and the tasks in each
+project and context. Completed tasks include those archived
+to the done file. With --filter, only matching tasks count.`,
+	Args: cobra.NoArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		printOnExit = false
+		tf, err := readFile(mainFile(), newOpts())
+		checkErr(err)
+		st, err := tf.Stats(statsDays, statsWeeks)
+		checkErr(err)
+		if statsJson {
+			js, err := json.Marshal(st)
+			checkErr(err)
+			fmt.Println(string(js))
+			return
+		}
+		printStats(st)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().IntVar(&statsDays, "days", statsDays, "number of days to show")
+	statsCmd.Flags().IntVar(&statsWeeks, "weeks", statsWeeks, "number of weeks to show")
+	statsCmd.Flags().BoolVar(&statsJson, "json", false, "output the statistics as JSON")
+}
@@ -26,8 +26,10 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"gotodotxt/tdt"
+)
+
+var (
+	keepNext = false
 )
 
 var toggleAliases = []string{"x", "mark"}
@@ -37,24 +39,24 @@ var toggleCmd = &cobra.Command{
 	Short:   "Toggle task state (aliases: " + strings.Join(toggleAliases, ", ") + ")",
 	Long: `Toggle the state of one or more tasks.
 
-N.B. Marking recurring tasks as not done will not remove 
-the recurring instance generated. You can use the delete
-command to delete the unwanted task.`,
+Completing a recurring task adds its next instance, and the
+completed task gets a next: tag with the new task's id.
+Marking it as not done again removes that instance, unless
+it has been completed too or --keep-next is given.`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		checkIds()
-		davMode = checkDavMode()
-		opts := tdt.Opts{
-			ShowFuture: showFuture,
-			SortOrder:  sortOrder,
-		}
-		file = tdt.Read(viper.GetString("file"), opts)
-		file.Toggle(ids...)
-		file.Write()
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
+		file.Toggle(keepNext, lineNumbers(file)...)
+		checkErr(file.Write())
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(toggleCmd)
+	toggleCmd.Flags().BoolVar(&keepNext, "keep-next", false, "keep the next instance of a recurring task marked as not done")
 }
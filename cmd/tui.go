@@ -23,6 +23,7 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -54,6 +55,12 @@ var (
 	grey = color.Gray.Render
 )
 
+// sourceLine is a task in the done or trash file, listed with h.
+type sourceLine struct {
+	source string
+	line   int
+}
+
 type model struct {
 	file         *tdt.TaskFile
 	rows         Rows
@@ -61,28 +68,44 @@ type model struct {
 	beginningEnd int
 	endBeginning int
 	selected     map[int]struct{}
+	selectedOld  map[sourceLine]struct{} // tasks of the done and trash files
 	command      string
 	textInput    textinput.Model
+	err          error
+	conflicts    []tdt.Conflict
+	view         int
+	collapsed    map[string]bool
 }
 
-func newModel(fn string) model {
+func newModel(fc fileConfig) (model, error) {
 	ti := textinput.New()
 	ti.Placeholder = "Parameters"
 	ti.Focus()
 	ti.CharLimit = 156
 	ti.Width = 20
 
-	opts := tdt.Opts{
-		ShowFuture: showFuture,
-		SortOrder:  sortOrder,
+	view, err := findView(viper.GetString("view"))
+	if err != nil {
+		return model{}, err
+	}
+	opts, err := viewOpts(view)
+	if err != nil {
+		return model{}, err
+	}
+	tf, err := watchFile(fc, opts)
+	if err != nil {
+		return model{}, err
 	}
 	m := model{
-		file:      tdt.Watch(fn, opts).Sort().Filter(),
-		selected:  make(map[int]struct{}),
-		textInput: ti,
+		file:        tf.Sort().Filter(),
+		selected:    make(map[int]struct{}),
+		selectedOld: make(map[sourceLine]struct{}),
+		textInput:   ti,
+		view:        view,
+		collapsed:   make(map[string]bool),
 	}
-	m.rows = renderTasks(m.file, false)
-	return m
+	m.render()
+	return m, nil
 }
 
 func (m model) Init() tea.Cmd {
@@ -102,29 +125,28 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tdt.FileChangedEvent:
 		// m.eep = msg.EventName
-		m.file = tdt.Watch(m.file.Path, m.file.Opts).Sort().Filter()
-		m.rows = renderTasks(m.file, false)
-		return m, waitForFileChanges(m.file.Events)
+		if msg.Err != nil {
+			m.err = msg.Err
+			return m, nil
+		}
+		return m, m.reload()
 
 	case tea.KeyMsg:
 		if m.command == "" {
 			// m.eep = msg.String()
+			m.err = nil
 			switch msg.String() {
 
 			case "1":
-				m.file = tdt.Watch(viper.GetString("file"), m.file.Opts)
-				m.reset(false)
-				return m, waitForFileChanges(m.file.Events)
+				return m, m.open(mainFile())
 
 			case "2", "3", "4", "5", "6", "7", "8", "9", "0":
 				num, err := strconv.Atoi(msg.String())
-				if err != nil {
+				if err != nil || num < 2 {
 					return m, nil
 				}
-				if len(viper.GetStringSlice("other-files")) > num-2 {
-					m.file = tdt.Watch(viper.GetStringSlice("other-files")[num-2], m.file.Opts)
-					m.reset(false)
-					return m, waitForFileChanges(m.file.Events)
+				if others := otherFiles(); len(others) > num-2 {
+					return m, m.open(others[num-2])
 				}
 
 			case "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K",
@@ -140,9 +162,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			case "e":
 				if len(m.selected) == 0 {
+					ids := m.getSelected()
+					if len(ids) == 0 {
+						return m, nil
+					}
 					m.command = "editOne"
 					// Log(log.Debug, m.cursor, len(m.file.Tasks), len(m.rows), m.rows[m.cursor].LineNumber)
-					orig := m.file.Original(m.rows[m.cursor].LineNumber)
+					orig := m.file.Original(ids[0])
 					m.textInput.Placeholder = orig
 					m.textInput.SetValue(orig)
 					m.textInput.SetCursor(0)
@@ -156,6 +182,33 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.file.Opts.ShowFuture = !m.file.Opts.ShowFuture
 				m.reset(false)
 
+			case "v":
+				m.setView(m.view + 1)
+
+			case "h":
+				if len(m.file.Opts.Include) > 0 {
+					m.file.Opts.Include = nil
+				} else {
+					m.file.Opts.Include = []string{tdt.SourceDone, tdt.SourceTrash}
+				}
+				m.reset(false)
+
+			case "u":
+				_, err := m.file.Undo()
+				m.showErr(err)
+				m.refresh(false)
+
+			case "ctrl+r":
+				_, err := m.file.Redo()
+				m.showErr(err)
+				m.refresh(false)
+
+			case "/":
+				m.command = "filter"
+				m.textInput.Placeholder = "+project @context pri:<=B due:<1w -done"
+				m.textInput.SetValue(m.file.Opts.Query.String())
+				m.textInput.CursorEnd()
+
 			case "g":
 				m.cursor = 0
 
@@ -178,11 +231,20 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.textInput.Reset()
 
 			case "q", "ctrl+c":
-				m.file.Write()
+				if m.write(); m.err != nil {
+					return m, nil
+				}
 				return m, tea.Quit
 
 			case "x":
-				m.file.Toggle(m.getSelected()...)
+				ids := m.getSelected()
+				if len(m.file.NextInstances(ids...)) > 0 {
+					m.command = "untoggle"
+					m.textInput.Placeholder = "Type yes to remove the next instance too"
+					m.textInput.Reset()
+					return m, nil
+				}
+				m.file.Toggle(false, ids...)
 				m.refresh(true)
 
 			case "backspace", "delete":
@@ -191,6 +253,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.textInput.Reset()
 
 			case "enter", " ":
+				if len(m.rows) == 0 {
+					return m, nil
+				}
+				if g := m.rows[m.cursor].Group; g != "" {
+					m.collapsed[g] = !m.collapsed[g]
+					m.render()
+					return m, nil
+				}
+				if r := m.rows[m.cursor]; r.Source != "" {
+					sl := sourceLine{r.Source, r.LineNumber}
+					if _, ok := m.selectedOld[sl]; ok {
+						delete(m.selectedOld, sl)
+					} else {
+						m.selectedOld[sl] = struct{}{}
+					}
+					return m, nil
+				}
 				ln := m.rows[m.cursor].LineNumber
 				_, ok := m.selected[ln]
 				if ok {
@@ -201,8 +280,18 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			case "esc":
 				m.selected = make(map[int]struct{})
+				m.selectedOld = make(map[sourceLine]struct{})
 				m.command = ""
 
+			case "r":
+				for source, nums := range m.getSelectedOld() {
+					if m.err = m.file.Restore(source, nums...); m.err != nil {
+						break
+					}
+				}
+				// Restore writes the file itself
+				m.reset(false)
+
 			case "[":
 				ids := m.getSelected()
 				m.file.Edit("t:1d due:1d", false, ids...)
@@ -229,7 +318,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch msg.String() {
 
 			case "enter":
-				switch m.command {
+				command := m.command
+				m.command = ""
+				switch command {
 				case "editOne":
 					ids := m.getSelected()
 					if len(ids) == 1 {
@@ -243,18 +334,31 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				case "new":
 					m.file.Add(m.textInput.Value())
 					m.refresh(true)
+				case "untoggle":
+					m.file.Toggle(!isYes(m.textInput.Value()), m.getSelected()...)
+					m.refresh(true)
 				case "archive":
 					if isYes(m.textInput.Value()) {
-						m.file.Archive()
-						m.reset(true)
+						// Archive writes the file itself
+						if m.err = m.file.Archive(); m.err == nil {
+							m.reset(false)
+						}
 					}
 				case "delete":
 					if isYes(m.textInput.Value()) {
-						m.file.Delete(m.getSelected()...)
-						m.reset(true)
+						if m.err = m.file.Delete(m.getSelected()...); m.err == nil {
+							m.reset(false)
+						}
+					}
+				case "conflict":
+					m.resolveConflict(m.textInput.Value())
+				case "filter":
+					q, err := tdt.ParseQuery(m.textInput.Value())
+					if m.err = err; err == nil {
+						m.file.Opts.Query = q
+						m.reset(false)
 					}
 				}
-				m.command = ""
 
 			case "esc":
 				m.command = ""
@@ -276,39 +380,158 @@ func (m model) View() string {
 }
 
 func (m *model) refresh(writeFile bool) {
-	m.file.Sort().Filter()
-	m.rows = renderTasks(m.file, false)
 	if writeFile {
-		go m.file.Write()
+		m.write()
 	}
+	m.file.Sort().Filter()
+	m.render()
+}
+
+// render makes the rows for the file, along with the done and trash files
+// if they are included.
+func (m *model) render() {
+	view, err := m.file.WithIncluded()
+	if err != nil {
+		m.err = err
+		view = m.file
+	}
+	m.rows = renderTasks(view.Sort().Filter(), false, m.collapsed)
+}
+
+// write saves the file. If it was changed elsewhere in ways that could
+// not be merged, the user is asked about each conflicting task in turn.
+func (m *model) write() {
+	m.showErr(m.file.Write())
+}
+
+// showErr shows err in the footer, or asks about each conflicting task if
+// err is a conflict with changes made elsewhere.
+func (m *model) showErr(err error) {
+	m.err = err
+	var ce *tdt.ConflictError
+	if errors.As(err, &ce) {
+		m.conflicts = ce.Conflicts
+		m.promptConflict()
+	}
+}
+
+func (m *model) promptConflict() {
+	m.command = "conflict"
+	m.textInput.Placeholder = "Keep m(ine), t(heirs) or b(oth)"
+	m.textInput.Reset()
+}
+
+func (m *model) resolveConflict(answer string) {
+	if len(m.conflicts) == 0 {
+		return
+	}
+	r := tdt.KeepOurs
+	switch strings.ToLower(answer) {
+	case "t", "theirs":
+		r = tdt.KeepTheirs
+	case "b", "both":
+		r = tdt.KeepBoth
+	}
+	m.file.Resolve(m.conflicts[0], r)
+	m.conflicts = m.conflicts[1:]
+	if len(m.conflicts) > 0 {
+		m.promptConflict()
+		return
+	}
+	m.refresh(true)
+}
+
+// open switches the model to watching another file. On failure the
+// current file is kept and the error is shown in the footer.
+func (m *model) open(fc fileConfig) tea.Cmd {
+	tf, err := watchFile(fc, m.file.Opts)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	m.file = tf
+	m.reset(false)
+	return waitForFileChanges(m.file.Events)
+}
+
+// reload rereads the current file after it has changed elsewhere.
+func (m *model) reload() tea.Cmd {
+	tf, err := tdt.WatchFrom(m.file.Storage, m.file.Path, m.file.Opts)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	m.file = tf
+	m.refresh(false)
+	return waitForFileChanges(m.file.Events)
+}
+
+// setView switches to view n, or to no view once n runs past the last.
+func (m *model) setView(n int) {
+	if n >= len(views()) {
+		n = -1
+	}
+	opts, err := viewOpts(n)
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.file.Opts = opts
+	m.view = n
+	m.reset(false)
 }
 
 func (m *model) reset(writeFile bool) {
 	m.selected = make(map[int]struct{})
+	m.selectedOld = make(map[sourceLine]struct{})
 	m.cursor = 0
 	m.refresh(writeFile)
 }
 
 func (m model) header() string {
 	selected := "Last change: " + m.file.LastUpdate.Local().Format("15:04:05")
-	if len(m.selected) > 0 {
-		selected = fmt.Sprintf("%d selected", len(m.selected))
+	if n := len(m.selected) + len(m.selectedOld); n > 0 {
+		selected = fmt.Sprintf("%d selected", n)
+	}
+	name := path.Base(m.file.Path)
+	if vs := views(); m.view >= 0 && m.view < len(vs) {
+		name += " • " + vs[m.view].Name
+	}
+	count := 0
+	for _, t := range m.file.Tasks {
+		if !t.FilteredOut {
+			count++
+		}
 	}
 	s := fmt.Sprintf("  %s • %d tasks • %s \n\n",
-		path.Base(m.file.Path),
-		len(m.rows),
+		name,
+		count,
 		selected,
 	)
 	return s
 }
 
 func (m model) footer() string {
+	if m.command == "conflict" && len(m.conflicts) > 0 {
+		c := m.conflicts[0]
+		return fmt.Sprintf("\n  %s\n  mine:   %s\n  theirs: %s\n  %s\n  %s",
+			color.Red.Render(fmt.Sprintf("%d conflicting changes", len(m.conflicts))),
+			orDeleted(c.Ours), orDeleted(c.Theirs),
+			m.textInput.View(), "(esc keeps mine)")
+	}
 	if m.command != "" {
 		return fmt.Sprintf("\n  %s\n  %s", m.textInput.View(), "(esc to cancel)")
 	} else {
-		s := "\n      " + grey("sort: "+sortOrder) + "\n"
-		s += "      spc:select n:new x:toggle e:edit q:quit a:archive\n"
-		s += "      f:future A-Z:pri z:no pri [:+1 day ]:+1 week"
+		status := "sort: " + m.file.Opts.SortOrder
+		if m.file.Opts.Query != nil {
+			status += "  filter: " + m.file.Opts.Query.String()
+		}
+		s := "\n      " + grey(status) + "\n"
+		if m.err != nil {
+			s = "\n      " + color.Red.Render(m.err.Error()) + "\n"
+		}
+		s += "      spc:select n:new x:toggle e:edit q:quit a:archive u:undo\n"
+		s += "      f:future /:filter v:view h:history r:restore A-Z:pri z:no pri [:+1 day ]:+1 week"
 		return s
 	}
 }
@@ -371,7 +594,11 @@ func (m model) body() string {
 		}
 
 		selected := " "
-		if _, ok := m.selected[int(r.LineNumber)]; ok {
+		_, ok := m.selected[int(r.LineNumber)]
+		if r.Source != "" {
+			_, ok = m.selectedOld[sourceLine{r.Source, r.LineNumber}]
+		}
+		if ok {
 			selected = "*"
 			// line1 = selectedStyle(line1)
 			// line2 = selectedStyle(line2)
@@ -396,6 +623,13 @@ func (m model) body() string {
 	return s
 }
 
+func orDeleted(line string) string {
+	if line == "" {
+		return grey("(deleted)")
+	}
+	return line
+}
+
 func isYes(txt string) bool {
 	return strings.ToLower(txt) == "yes"
 }
@@ -403,7 +637,9 @@ func isYes(txt string) bool {
 func (m *model) getSelected() []int {
 	var selected []int
 	if len(m.selected) == 0 {
-		selected = append(selected, m.rows[m.cursor].LineNumber)
+		if m.cursor < len(m.rows) && m.rows[m.cursor].Group == "" && m.rows[m.cursor].Source == "" {
+			selected = append(selected, m.rows[m.cursor].LineNumber)
+		}
 	} else {
 		for k := range m.selected {
 			selected = append(selected, k)
@@ -412,6 +648,23 @@ func (m *model) getSelected() []int {
 	return selected
 }
 
+// getSelectedOld returns the line numbers of the selected tasks of the
+// done and trash files, or of the one under the cursor, by file.
+func (m *model) getSelectedOld() map[string][]int {
+	selected := make(map[string][]int)
+	if len(m.selectedOld) == 0 {
+		if m.cursor < len(m.rows) && m.rows[m.cursor].Source != "" {
+			r := m.rows[m.cursor]
+			selected[r.Source] = append(selected[r.Source], r.LineNumber)
+		}
+	} else {
+		for sl := range m.selectedOld {
+			selected[sl.source] = append(selected[sl.source], sl.line)
+		}
+	}
+	return selected
+}
+
 func waitForFileChanges(changed chan tdt.FileChangedEvent) tea.Cmd {
 	return func() tea.Msg {
 		return tdt.FileChangedEvent(<-changed)
@@ -420,9 +673,10 @@ func waitForFileChanges(changed chan tdt.FileChangedEvent) tea.Cmd {
 
 func tui() {
 	printOnExit = false
-	davMode = checkDavMode()
 
-	p := tea.NewProgram(newModel(viper.GetString("file")), tea.WithAltScreen())
+	m, err := newModel(mainFile())
+	checkErr(err)
+	p := tea.NewProgram(m, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("%v", err)
 		os.Exit(1)
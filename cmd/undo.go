@@ -0,0 +1,71 @@
+/*
+Copyright © 2022 Jason Quigley <jason@jasonquigley.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gotodotxt/tdt"
+)
+
+var (
+	redo = false
+)
+
+var undoAliases = []string{"u"}
+var undoCmd = &cobra.Command{
+	Use:     "undo",
+	Aliases: undoAliases,
+	Short:   "Undo the last change (aliases: " + strings.Join(undoAliases, ", ") + ")",
+	Long: `Undo the last change to the tasks file.
+
+Every change made by gotodotxt, from the command line or the
+TUI, is kept in a journal next to the tasks file (todo.txt.undo
+for todo.txt), so changes can be undone one at a time. Tasks
+moved to the done or trash file are moved back. With --redo,
+the change most recently undone is made again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := newOpts()
+		var err error
+		file, err = readFile(mainFile(), opts)
+		checkErr(err)
+		var op tdt.Operation
+		if redo {
+			op, err = file.Redo()
+		} else {
+			op, err = file.Undo()
+		}
+		checkErr(err)
+		verb := "Undid"
+		if redo {
+			verb = "Redid"
+		}
+		fmt.Printf("%s %s from %s\n\n", verb, op.Name, op.Time.Local().Format("2006-01-02 15:04:05"))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+	undoCmd.Flags().BoolVarP(&redo, "redo", "r", false, "redo the last undone change")
+}
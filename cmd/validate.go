@@ -0,0 +1,95 @@
+/*
+Copyright © 2022 Jason Quigley <jason@jasonquigley.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gookit/color"
+	"github.com/spf13/cobra"
+	"gotodotxt/tdt"
+)
+
+var (
+	fixLines = false
+)
+
+var validateAliases = []string{"lint", "check"}
+var validateCmd = &cobra.Command{
+	Use:     "validate",
+	Aliases: validateAliases,
+	Short:   "Check the tasks file follows the todo.txt format (aliases: " + strings.Join(validateAliases, ", ") + ")",
+	Long: `Check the tasks file follows the todo.txt format
+
+Each line that doesn't is listed with its line number and
+the reason. With --fix, the problems that can be fixed are:
+spacing, the case of the x and priority, a priority after
+the completion date (which is moved to a pri: tag), and due
+and threshold dates written in words. The others, such as a
+missing creation date, are left to be fixed by hand. The
+fixes can be undone like any other change.
+
+The exit code is 6 if any problems are left.`,
+	Args: cobra.NoArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		printOnExit = false
+		fc := mainFile()
+		s, err := fc.storage()
+		checkErr(err)
+		before, err := tdt.LintFrom(s, fc.Path)
+		checkErr(err)
+		problems := before
+		if fixLines && anyFixable(before) {
+			file, err = readFile(fc, newOpts())
+			checkErr(err)
+			checkErr(file.Fix().Write())
+			problems, err = tdt.LintFrom(s, fc.Path)
+			checkErr(err)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s %s\n", p, color.Gray.Render(p.Text))
+		}
+		if fixLines && len(problems) < len(before) {
+			fmt.Printf("Fixed %d of %d problems\n", len(before)-len(problems), len(before))
+		}
+		if len(problems) > 0 {
+			checkErr(fmt.Errorf("%w: %d problems in %s", tdt.ErrParse, len(problems), fc.Path))
+		}
+	},
+}
+
+func anyFixable(problems []tdt.Problem) bool {
+	for _, p := range problems {
+		if p.Fixable {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().BoolVar(&fixLines, "fix", false, "fix the problems that can be fixed")
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gotodotxt/tdt"
+)
+
+// viewConfig is a saved combination of filter, sort order, future setting
+// and grouping, taken from the "views" setting:
+//
+//	views:
+//	  - name: work
+//	    filter: +work -done
+//	    sort: priority,due-
+//	    group: project
+//	  - name: someday
+//	    filter: future OR pri:none
+//	    future: true
+//	  - name: history
+//	    filter: done
+//	    include: done, trash
+type viewConfig struct {
+	Name    string
+	Filter  string
+	Sort    string
+	Future  bool
+	Group   string
+	Include []string
+}
+
+// views returns the entries of the "views" setting.
+func views() []viewConfig {
+	var vs []viewConfig
+	entries, _ := viper.Get("views").([]interface{})
+	for _, e := range entries {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		v := viewConfig{
+			Name:   configString(m, "name"),
+			Filter: configString(m, "filter"),
+			Sort:   configString(m, "sort"),
+			Group:  configString(m, "group"),
+		}
+		v.Include = includeList(configString(m, "include"))
+		v.Future, _ = strconv.ParseBool(configString(m, "future"))
+		if v.Name == "" {
+			continue
+		}
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+// findView returns the index of the named view, or -1 if name is empty.
+func findView(name string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	var names []string
+	for i, v := range views() {
+		if strings.EqualFold(v.Name, name) {
+			return i, nil
+		}
+		names = append(names, v.Name)
+	}
+	if len(names) == 0 {
+		return -1, fmt.Errorf("unknown view %q (no views are configured)", name)
+	}
+	return -1, fmt.Errorf("unknown view %q (views are: %s)", name, strings.Join(names, ", "))
+}
+
+// viewOpts returns the options for view n, or for no view if n is -1.
+// A view's sort order, future setting, grouping and included files give
+// way to --sort, --future, --group-by and --include when those are set on
+// the command line, and --filter narrows the view's own filter.
+func viewOpts(n int) (tdt.Opts, error) {
+	opts := tdt.Opts{
+		ShowFuture:     showFuture,
+		SortOrder:      sortOrder,
+		AutoID:         viper.GetBool("auto-id"),
+		GroupBy:        viper.GetString("group-by"),
+		GroupFirstOnly: viper.GetBool("group-first"),
+		Include:        includeList(viper.GetStringSlice("include")...),
+	}
+	filter := filterExpr
+	if vs := views(); n >= 0 && n < len(vs) {
+		v := vs[n]
+		if v.Sort != "" && !globalFlags.Changed("sort") {
+			opts.SortOrder = v.Sort
+		}
+		if !globalFlags.Changed("future") {
+			opts.ShowFuture = v.Future
+		}
+		if v.Group != "" && !globalFlags.Changed("group-by") {
+			opts.GroupBy = v.Group
+		}
+		if len(v.Include) > 0 && !globalFlags.Changed("include") {
+			opts.Include = v.Include
+		}
+		filter = combineFilters(v.Filter, filterExpr)
+	}
+	if err := tdt.CheckSortOrder(opts.SortOrder); err != nil {
+		return opts, err
+	}
+	if err := tdt.CheckGroupBy(opts.GroupBy); err != nil {
+		return opts, err
+	}
+	if err := tdt.CheckInclude(opts.Include); err != nil {
+		return opts, err
+	}
+	q, err := tdt.ParseQuery(filter)
+	if err != nil {
+		return opts, err
+	}
+	opts.Query = q
+	return opts, nil
+}
+
+// includeList splits the files to include, which may be separated by
+// commas or spaces, and expands all to both the done and trash files.
+func includeList(values ...string) []string {
+	var include []string
+	for _, v := range values {
+		for _, name := range strings.FieldsFunc(strings.ToLower(v), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '[' || r == ']'
+		}) {
+			if name == "all" {
+				include = append(include, tdt.SourceDone, tdt.SourceTrash)
+			} else {
+				include = append(include, name)
+			}
+		}
+	}
+	return include
+}
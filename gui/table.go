@@ -83,7 +83,11 @@ func Init(fn string) model {
 		SortOrder:  "done,priority,due-,threshold-",
 		ShowFuture: false,
 	}
-	m.file = tdt.Read(fn, opts).Sort().Filter()
+	tf, err := tdt.Read(fn, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m.file = tf.Sort().Filter()
 	for _, t := range m.file.Tasks {
 		if t.FilteredOut {
 			continue
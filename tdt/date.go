@@ -0,0 +1,77 @@
+package tdt
+
+import (
+	"time"
+)
+
+// Dates in task files have no time of day, so tdt works with civil dates:
+// each is held as midnight in Location, and compared by day. Location
+// decides when "today" starts, and so which tasks are overdue.
+var (
+	Location = time.Local
+	now      = time.Now
+)
+
+// SetLocation sets the timezone used for dates, such as "Europe/Dublin".
+// An empty name means the local timezone.
+func SetLocation(name string) error {
+	if name == "" {
+		Location = time.Local
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	Location = loc
+	return nil
+}
+
+// SetClock replaces the function used to get the current time, so that
+// tests can fix it. Passing nil restores time.Now.
+func SetClock(clock func() time.Time) {
+	if clock == nil {
+		clock = time.Now
+	}
+	now = clock
+}
+
+// Today returns the start of the current day in Location.
+func Today() time.Time {
+	return civil(now())
+}
+
+// civil returns midnight in Location on the day that t falls on there.
+func civil(t time.Time) time.Time {
+	t = t.In(Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, Location)
+}
+
+// daysBetween returns the number of days from a to b, ignoring the time of
+// day and any daylight saving changes in between.
+func daysBetween(a, b time.Time) int {
+	a, b = a.In(Location), b.In(Location)
+	x := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	y := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(y.Sub(x).Hours() / 24)
+}
+
+// setDueStatus sets Overdue and DueToday as of today.
+func (t *Task) setDueStatus(today time.Time) {
+	t.Overdue = t.HasDue && !t.IsDone() && t.Due.Before(today)
+	t.DueToday = t.HasDue && !t.IsDone() && YMD(t.Due) == YMD(today)
+}
+
+// IsFuture reports whether the task has a threshold date after today.
+func (t *Task) IsFuture() bool {
+	return t.HasThreshold && t.Threshold.After(Today())
+}
+
+// Age returns the number of days since the task was created, or 0 if it
+// has no creation date.
+func (t *Task) Age() int {
+	if t.Created.IsZero() {
+		return 0
+	}
+	return daysBetween(t.Created, Today())
+}
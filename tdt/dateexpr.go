@@ -0,0 +1,219 @@
+package tdt
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	offsetRegex     = regexp.MustCompile(`^([+-]?)(\d*)([dwmyb])$`)
+	dayOfMonthRegex = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+	dueOffsetRegex  = regexp.MustCompile(`^(?i:due)([+-]\d*[dwmyb])$`)
+)
+
+var months = map[string]time.Month{
+	"jan": time.January, "january": time.January,
+	"feb": time.February, "february": time.February,
+	"mar": time.March, "march": time.March,
+	"apr": time.April, "april": time.April,
+	"may": time.May,
+	"jun": time.June, "june": time.June,
+	"jul": time.July, "july": time.July,
+	"aug": time.August, "august": time.August,
+	"sep": time.September, "sept": time.September, "september": time.September,
+	"oct": time.October, "october": time.October,
+	"nov": time.November, "november": time.November,
+	"dec": time.December, "december": time.December,
+}
+
+var offsetUnits = map[string]string{
+	"d": "d", "day": "d", "days": "d",
+	"w": "w", "week": "w", "weeks": "w",
+	"m": "m", "month": "m", "months": "m",
+	"y": "y", "year": "y", "years": "y",
+	"b": "b", "bday": "b", "bdays": "b",
+}
+
+// parseDate parses a due or threshold date. Inside a tag the words of an
+// expression are joined with hyphens, as in due:next-fri; elsewhere they
+// can be separated by spaces. The forms are:
+//
+//	2024-05-01               that date
+//	today tomorrow yesterday
+//	fri friday               the next Friday, which may be today
+//	next-fri                 the next Friday after today
+//	3d 2w 1m 1y 5b           days, weeks, months, years or business days
+//	in-3-days in-2w          from today; next-week and next-month are 1w and 1m
+//	-7d 7d-ago 2-weeks-ago   before today
+//	eow eom eoy              the end of this week (Sunday), month or year,
+//	                         also written end-of-week and so on
+//	jan-15 15-jan 15th       the next such day of the year or month
+//
+// Offsets and any other rec: pattern, such as 2nd-tue, count from today,
+// or from the date being changed if they start with a +.
+func parseDate(expr string, from time.Time) (time.Time, bool) {
+	if d, err := parseYMD(expr); err == nil {
+		return d, true
+	}
+	today := Today()
+	s := strings.ToLower(expr)
+	if strings.HasPrefix(s, "-") {
+		return addOffset(today, s)
+	}
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '-' || unicode.IsSpace(r)
+	})
+	if len(words) == 3 && words[0] == "end" && words[1] == "of" {
+		words = []string{"eo" + words[2][:1]}
+	}
+	switch len(words) {
+	case 1:
+		if d, ok := namedDate(words[0], today); ok {
+			return d, true
+		}
+	case 2:
+		if words[0] == "next" {
+			if day, ok := weekdays[words[1]]; ok {
+				return today.AddDate(0, 0, 1+int((6+day-today.Weekday())%7)), true
+			}
+			if unit, ok := offsetUnits[words[1]]; ok {
+				return addOffset(today, "1"+unit)
+			}
+		}
+		if words[0] == "in" {
+			return addOffset(today, words[1])
+		}
+		if words[1] == "ago" {
+			return addOffset(today, "-"+words[0])
+		}
+		if d, ok := yearDay(words[0], words[1], today); ok {
+			return d, true
+		}
+		if d, ok := yearDay(words[1], words[0], today); ok {
+			return d, true
+		}
+	case 3:
+		if unit, ok := offsetUnits[words[2]]; ok && words[0] == "in" {
+			return addOffset(today, words[1]+unit)
+		}
+		if unit, ok := offsetUnits[words[1]]; ok && words[2] == "ago" {
+			return addOffset(today, "-"+words[0]+unit)
+		}
+	}
+	if r := newRecurrence(strings.Join(strings.Fields(s), "-")); r.Period != "" {
+		return civil(r.getNextDate(from)), true
+	}
+	return time.Time{}, false
+}
+
+// namedDate parses the one-word forms of parseDate that need no offset.
+func namedDate(word string, today time.Time) (time.Time, bool) {
+	switch word {
+	case "today", "t", "tday", "tod":
+		return today, true
+	case "tomorrow", "tm", "tom":
+		return today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), true
+	case "eow":
+		return today.AddDate(0, 0, int(7-today.Weekday())%7), true
+	case "eom":
+		return lastOfMonth(today, 0), true
+	case "eoy":
+		return time.Date(today.Year(), time.December, 31, 0, 0, 0, 0, Location), true
+	}
+	if day, ok := weekdays[word]; ok {
+		return today.AddDate(0, 0, int((7+day-today.Weekday())%7)), true
+	}
+	if m := dayOfMonthRegex.FindStringSubmatch(word); m != nil && m[2] != "" {
+		day, _ := strconv.Atoi(m[1])
+		for i := 0; i < 12 && day > 0; i++ {
+			d := time.Date(today.Year(), today.Month()+time.Month(i), day, 0, 0, 0, 0, Location)
+			if d.Day() == day && !d.Before(today) {
+				return d, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// yearDay returns the next date, from today on, in the given month and on
+// the given day, such as jan and 15th.
+func yearDay(month, day string, today time.Time) (time.Time, bool) {
+	m, ok := months[month]
+	found := dayOfMonthRegex.FindStringSubmatch(day)
+	if !ok || found == nil {
+		return time.Time{}, false
+	}
+	n, _ := strconv.Atoi(found[1])
+	// Leap days come round at least every eight years
+	for i := 0; i <= 8 && n > 0; i++ {
+		d := time.Date(today.Year()+i, m, n, 0, 0, 0, 0, Location)
+		if d.Day() == n && !d.Before(today) {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// addOffset moves d by an offset such as 3d, -2w or +1b. The number
+// defaults to one.
+func addOffset(d time.Time, offset string) (time.Time, bool) {
+	found := offsetRegex.FindStringSubmatch(strings.ToLower(offset))
+	if found == nil {
+		return time.Time{}, false
+	}
+	n := 1
+	if found[2] != "" {
+		n, _ = strconv.Atoi(found[2])
+	}
+	if found[1] == "-" {
+		n = -n
+	}
+	switch found[3] {
+	case "d":
+		return d.AddDate(0, 0, n), true
+	case "w":
+		return d.AddDate(0, 0, 7*n), true
+	case "m":
+		return d.AddDate(0, n, 0), true
+	case "y":
+		return d.AddDate(n, 0, 0), true
+	}
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for n > 0 {
+		d = d.AddDate(0, 0, step)
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			n--
+		}
+	}
+	return d, true
+}
+
+// parseThreshold is parseDate for thresholds, which can also be set
+// relative to the task's due date, as in t:due-2d.
+func parseThreshold(expr string, from time.Time, t *Task) (time.Time, bool) {
+	if found := dueOffsetRegex.FindStringSubmatch(expr); found != nil {
+		if !t.HasDue {
+			return time.Time{}, false
+		}
+		return addOffset(t.Due, found[1])
+	}
+	return parseDate(expr, from)
+}
+
+// validDate reports whether expr is a date that parseThreshold accepts,
+// or x, which removes a date.
+func validDate(expr string) bool {
+	if strings.EqualFold(expr, "x") || dueOffsetRegex.MatchString(expr) {
+		return true
+	}
+	_, ok := parseDate(expr, Today())
+	return ok
+}
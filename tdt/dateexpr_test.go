@@ -0,0 +1,93 @@
+package tdt
+
+import "testing"
+
+func TestParseDate(t *testing.T) {
+	fixClock(t, "2024-05-01") // a Wednesday
+	from := date("2024-06-10")
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"2024-12-25", "2024-12-25"},
+		{"today", "2024-05-01"},
+		{"Tomorrow", "2024-05-02"},
+		{"yesterday", "2024-04-30"},
+		{"wed", "2024-05-01"},
+		{"friday", "2024-05-03"},
+		{"next-wed", "2024-05-08"},
+		{"next fri", "2024-05-03"},
+		{"next-week", "2024-05-08"},
+		{"3d", "2024-05-04"},
+		{"2w", "2024-05-15"},
+		{"1m", "2024-06-01"},
+		{"+1w", "2024-06-17"},
+		{"+1b", "2024-06-11"},
+		{"in-3-days", "2024-05-04"},
+		{"in 2 weeks", "2024-05-15"},
+		{"in-1m", "2024-06-01"},
+		{"-3d", "2024-04-28"},
+		{"2w-ago", "2024-04-17"},
+		{"1 month ago", "2024-04-01"},
+		{"in 2 bdays", "2024-05-03"},
+		{"eow", "2024-05-05"},
+		{"eom", "2024-05-31"},
+		{"end-of-month", "2024-05-31"},
+		{"end of year", "2024-12-31"},
+		{"jan-15", "2025-01-15"},
+		{"15-jan", "2025-01-15"},
+		{"may 1st", "2024-05-01"},
+		{"feb-29", "2028-02-29"},
+		{"15th", "2024-05-15"},
+		{"1st", "2024-05-01"},
+		{"30th", "2024-05-30"},
+		{"31st", "2024-05-31"},
+		{"2nd-tue", "2024-05-14"},
+		{"", ""},
+		{"blah", ""},
+		{"next-blah", ""},
+		{"feb-30", ""},
+		{"32nd", ""},
+		{"in-3", ""},
+	}
+	for _, tt := range tests {
+		d, ok := parseDate(tt.expr, from)
+		got := ""
+		if ok {
+			got = YMD(d)
+		}
+		if got != tt.want {
+			t.Errorf("parseDate(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestThresholdFromDue(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"a due:2024-05-10 t:due-2d", "a due:2024-05-10 t:2024-05-08"},
+		{"a t:due-1w due:fri", "a t:2024-04-26 due:2024-05-03"},
+		{"a due:2024-05-13 t:due-1b", "a due:2024-05-13 t:2024-05-10"},
+		{"a t:due-2d", "a t:due-2d"},
+		{"a due:blah", "a due:blah"},
+	}
+	for _, tt := range tests {
+		task, err := parseNewTask(tt.line)
+		if err != nil {
+			t.Fatalf("parseNewTask(%q): %v", tt.line, err)
+		}
+		if task.original != tt.want {
+			t.Errorf("parseNewTask(%q) = %q, want %q", tt.line, task.original, tt.want)
+		}
+	}
+
+	tf, _ := newTestFile(t, "b due:2024-05-10 t:2024-05-01", "c")
+	tf.Edit("due:+1w t:due-3d", true, 0, 1)
+	want := []string{"b due:2024-05-17 t:2024-05-14", "c due:2024-05-08 t:2024-05-05"}
+	if got := originals(tf); !equalLines(got, want) {
+		t.Errorf("after Edit: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package tdt
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// Error kinds. Errors returned by this package can be tested against these
+// with errors.Is.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrPermission        = errors.New("permission denied")
+	ErrConflict          = errors.New("conflict")
+	ErrRemoteUnavailable = errors.New("remote unavailable")
+	ErrParse             = errors.New("parse error")
+	ErrLocked            = errors.New("locked by another process")
+)
+
+// FileError describes a failed operation on a tasks file.
+type FileError struct {
+	Op   string
+	Path string
+	Kind error
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Kind)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+func (e *FileError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// ParseError reports a line in a tasks file that could not be parsed.
+type ParseError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v: %q", e.Line+1, e.Err, e.Text)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrParse
+}
+
+func newFileError(op, fn string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var fe *FileError
+	if errors.As(err, &fe) {
+		return err
+	}
+	return &FileError{Op: op, Path: fn, Kind: errorKind(err), Err: err}
+}
+
+// newDavError is newFileError for WebDAV requests, where anything that is
+// not a recognised status is treated as the server being unreachable.
+func newDavError(op, fn string, err error) error {
+	if err == nil {
+		return nil
+	}
+	kind := errorKind(err)
+	if kind == nil {
+		kind = ErrRemoteUnavailable
+	}
+	return &FileError{Op: op, Path: fn, Kind: kind, Err: err}
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+func errorKind(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), gowebdav.IsErrNotFound(err):
+		return ErrNotFound
+	case errors.Is(err, fs.ErrPermission),
+		gowebdav.IsErrCode(err, 401), gowebdav.IsErrCode(err, 403):
+		return ErrPermission
+	case gowebdav.IsErrCode(err, 409), gowebdav.IsErrCode(err, 412):
+		return ErrConflict
+	case errors.Is(err, ErrParse):
+		return ErrParse
+	}
+	return nil
+}
@@ -24,280 +24,219 @@ package tdt
 
 import (
 	"bufio"
-	"io"
-	"os"
+	"bytes"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/mitchellh/go-homedir"
-	"github.com/studio-b12/gowebdav"
 )
 
 var (
-	davUrl       = ""
-	davUser      = ""
-	davPassword  = ""
-	davTmpDir    = ""
-	davMode      = false
 	stopWatching chan struct{}
 	writeLock    = sync.RWMutex{}
 )
 
-func SetWebdavCredentials(url, user, password, tmpDir string) bool {
-	if url == "" || user == "" || password == "" {
-		return false
+// relativeFileName returns the name of a file kept alongside fn, such as
+// its done or trash file.
+func relativeFileName(fn, name string) string {
+	relFn := path.Join(path.Dir(fn), name+".txt")
+	if path.Base(fn) != "todo.txt" {
+		base := strings.TrimSuffix(path.Base(fn), ".txt")
+		relFn = path.Join(path.Dir(fn), base+"_"+name+".txt")
 	}
-	davMode = true
-	davUrl = url
-	davUser = user
-	davPassword = password
-	davTmpDir = tmpDir
-	return true
+	return relFn
 }
 
-func getRelativeFileName(fn string) string {
-	Log(log.Debug, todoFile)
-	relFn := path.Join(path.Dir(todoFile), fn+".txt")
-	if path.Base(todoFile) != "todo.txt" {
-		name := strings.TrimSuffix(path.Base(todoFile), ".txt")
-		relFn = path.Join(path.Dir(todoFile), name+"_"+fn+".txt")
-	}
-	return relFn
+// Read loads fn from DefaultStorage.
+func Read(fn string, opts Opts) (*TaskFile, error) {
+	return ReadFrom(DefaultStorage, fn, opts)
 }
 
-func Read(fn string, opts Opts) *TaskFile {
-	todoFile = fn
+// ReadFrom loads fn from s, creating it if it does not exist yet. The done
+// and trash files are placed next to it on the same storage.
+func ReadFrom(s Storage, fn string, opts Opts) (*TaskFile, error) {
 	var err error
 	fn, err = homedir.Expand(fn)
 	if err != nil {
-		panic(err)
-	}
-	_, err = os.Stat(fn)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			panic(err)
-		}
-		_, err := os.Create(fn)
-		if err != nil {
-			panic(err)
+		return nil, newFileError("read", fn, err)
+	}
+	taskFile := TaskFile{
+		Path:      fn,
+		Storage:   s,
+		Opts:      opts,
+		DoneFile:  &TaskFile{Path: relativeFileName(fn, "done"), Storage: s},
+		TrashFile: &TaskFile{Path: relativeFileName(fn, "trash"), Storage: s},
+	}
+	data, modTime, err := s.Read(fn)
+	if isNotFound(err) {
+		if err := s.Write(fn, nil); err != nil {
+			return nil, err
 		}
+		data, modTime, err = s.Read(fn)
 	}
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	taskFile := TaskFile{Path: fn, Opts: opts}
-	if davMode {
-		fn, taskFile.LastUpdate = downloadWebdavFile(fn)
-		taskFile.Tasks, _ = readTasksFile(fn)
-	} else {
-		taskFile.Tasks, taskFile.LastUpdate = readTasksFile(fn)
-	}
-	return &taskFile
-}
-
-func readTasksFile(fn string) (Tasks, time.Time) {
-	file, err := os.Open(fn)
+	taskFile.LastUpdate = modTime
+	taskFile.Tasks, err = readTasks(data)
 	if err != nil {
-		log.Fatalf("readTasksFile %v", err)
+		return nil, newFileError("read", fn, err)
 	}
-	defer file.Close()
+	taskFile.setBase(taskFile.Tasks)
+	return &taskFile, nil
+}
 
+func readTasks(data []byte) (Tasks, error) {
 	var tasks []Task
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	var lineNumber int = 0
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 		t, err := parseTask(line)
 		if err != nil {
-			continue
+			// Dropping the line here would lose it on the next write, and
+			// failing would stop every command; validate reports it
+			Log(log.Warning, "line "+strconv.Itoa(lineNumber+1)+": "+err.Error())
+			t = rawTask(line)
 		}
 		t.LineNumber = lineNumber
 		lineNumber++
 		tasks = append(tasks, t)
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("readTasksFile %v", err)
-	}
-
-	info, err := os.Stat(fn)
-	if err != nil {
-		panic(err)
-	}
+	return tasks, scanner.Err()
+}
 
-	return tasks, info.ModTime()
+// Watch loads fn from DefaultStorage and watches it for changes.
+func Watch(fn string, opts Opts) (*TaskFile, error) {
+	return WatchFrom(DefaultStorage, fn, opts)
 }
 
-func Watch(fn string, opts Opts) *TaskFile {
-	var err error
-	fn, err = homedir.Expand(fn)
+// WatchFrom loads fn from s and sends an event on the returned file's
+// Events channel whenever fn changes. Only one file is watched at a time;
+// watching a new one stops the previous watcher. If watching fails, a
+// single event carrying the error is sent.
+func WatchFrom(s Storage, fn string, opts Opts) (*TaskFile, error) {
+	taskFile, err := ReadFrom(s, fn, opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	taskFile := Read(fn, opts)
 	taskFile.Events = make(chan FileChangedEvent)
-	if davMode {
-		go watchWebdavFile(fn, taskFile.Events)
-	} else {
-		go watchLocalFile(fn, taskFile.Events)
-	}
-	return taskFile
-}
-
-func watchWebdavFile(fn string, changed chan FileChangedEvent) {
 	if stopWatching != nil {
-		stopWatching <- struct{}{}
-		time.Sleep(1 * time.Second)
+		close(stopWatching)
 	}
 	stopWatching = make(chan struct{})
-
-	c := gowebdav.NewClient(davUrl, davUser, davPassword)
-	info, err := c.Stat(fn)
-	if err != nil {
-		panic(err)
-	}
-	updatedAt := info.ModTime()
-	ticker := time.NewTicker(5 * time.Second)
-	for {
-		select {
-		case <-stopWatching:
-			Log(log.Warning, "stop watching "+fn)
-			return
-		case <-ticker.C:
-			Log(log.Warning, "checking "+fn)
-			info, err := c.Stat(fn)
-			if err != nil {
-				panic(err)
-			}
-			if info.ModTime().After(updatedAt) {
-				Log(log.Warning, "modified "+fn)
-				updatedAt = info.ModTime()
-				changed <- FileChangedEvent{"WebDAV"}
+	go func(events chan FileChangedEvent, stop chan struct{}) {
+		err := s.Watch(taskFile.Path, events, stop)
+		if err != nil {
+			select {
+			case events <- FileChangedEvent{EventName: "error", Err: err}:
+			case <-stop:
 			}
 		}
-	}
+	}(taskFile.Events, stopWatching)
+	return taskFile, nil
 }
 
-func watchLocalFile(fn string, changed chan FileChangedEvent) {
-	if stopWatching != nil {
-		stopWatching <- struct{}{}
-		time.Sleep(1 * time.Second)
-	}
-	stopWatching = make(chan struct{})
+func (tf *TaskFile) Write() error {
+	return tf.write(false)
+}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		panic(err)
-	}
-	defer watcher.Close()
-	err = watcher.Add(fn)
+func (tf *TaskFile) write(addToEnd bool) error {
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	unlock, err := tf.lock()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	changing := false
-	for {
-		select {
-		case <-stopWatching:
-			Log(log.Warning, "stop watching "+fn)
-			return
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return
-			}
-			// This works around multiple FSEvents on macOS
-			if !changing {
-				Log(log.Warning, "modified "+fn)
-				changing = true
-				go func() {
-					time.Sleep(time.Second * 4)
-					changed <- FileChangedEvent{event.Op.String()}
-					changing = false
-				}()
-			}
+	defer unlock()
+	if !addToEnd {
+		if err := tf.mergeChanges(); err != nil {
+			return err
 		}
 	}
-}
-
-func (tf *TaskFile) Write() {
-	tf.write(false)
-}
 
-func (tf *TaskFile) write(addToEnd bool) {
-	writeLock.Lock()
-	if davMode {
-		tmp, _ := downloadWebdavFile(tf.Path)
-		tf.writeLocalFile(tmp, addToEnd)
-		uploadWebdavFile(tmp, tf.Path)
-	} else {
-		tf.writeLocalFile(tf.Path, addToEnd)
+	tasks := tf.sort("").Tasks
+	if SortFile {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return tasks[i].original < tasks[j].original
+		})
 	}
-	writeLock.Unlock()
-}
-
-func (tf *TaskFile) writeLocalFile(fn string, addToEnd bool) {
-	var newContents []string
-	for _, t := range tf.sort("").Tasks {
+	var buf bytes.Buffer
+	for _, t := range tasks {
 		// Log(log.Debug, t.Original)
-		newContents = append(newContents, t.original)
-	}
-	if SortFile {
-		sort.Strings(newContents)
+		buf.WriteString(t.original + "\n")
 	}
-
-	var f *os.File
-	var err error
 	if addToEnd {
-		opts := os.O_APPEND | os.O_CREATE | os.O_WRONLY
-		f, err = os.OpenFile(fn, opts, 0644)
-	} else {
-		f, err = os.Create(fn)
+		return tf.Storage.Append(tf.Path, buf.Bytes())
 	}
-	if err != nil {
-		panic(err)
+	if err := tf.Storage.Write(tf.Path, buf.Bytes()); err != nil {
+		return err
 	}
-	defer f.Close()
-	for _, t := range newContents {
-		_, err = f.WriteString(t + "\n")
-		if err != nil {
-			panic(err)
-		}
+	tf.setBase(tasks)
+	modTime, err := tf.Storage.Stat(tf.Path)
+	if err != nil {
+		return err
 	}
+	tf.LastUpdate = modTime
+	return tf.saveHistory()
 }
 
-func downloadWebdavFile(fn string) (string, time.Time) {
-	tmp := path.Join(davTmpDir, "__"+path.Base(fn))
-
-	file, err := os.Create(tmp)
-	if err != nil {
-		log.Fatalf("downloadWebdavFile %v", err)
+// lock takes the storage's lock on the task file, unless tf holds it
+// already, and returns a func that releases it.
+func (tf *TaskFile) lock() (func(), error) {
+	if tf.locked {
+		return func() {}, nil
 	}
-	defer file.Close()
+	if err := tf.Storage.Lock(tf.Path); err != nil {
+		return nil, err
+	}
+	tf.locked = true
+	return func() {
+		tf.locked = false
+		tf.Storage.Unlock(tf.Path)
+	}, nil
+}
 
-	c := gowebdav.NewClient(davUrl, davUser, davPassword)
-	reader, err := c.ReadStream(fn)
-	if e, ok := err.(*os.PathError); ok && e.Err.Error() == "404" {
-		return tmp, time.Now()
+// mergeChanges checks whether the file was modified since it was last
+// read or written and, if so, merges those changes into tf.
+func (tf *TaskFile) mergeChanges() error {
+	if tf.LastUpdate.IsZero() {
+		return nil
+	}
+	modTime, err := tf.Storage.Stat(tf.Path)
+	if isNotFound(err) {
+		return nil
 	} else if err != nil {
-		log.Fatalf("downloadWebdavFile %v", err)
+		return err
 	}
-
-	info, err := c.Stat(fn)
+	if modTime.Equal(tf.LastUpdate) {
+		return nil
+	}
+	Log(log.Warning, "merging changes to "+tf.Path)
+	data, modTime, err := tf.Storage.Read(tf.Path)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	io.Copy(file, reader)
-
-	return tmp, info.ModTime()
+	theirs, err := readTasks(data)
+	if err != nil {
+		return newFileError("merge", tf.Path, err)
+	}
+	tf.LastUpdate = modTime
+	if conflicts := tf.merge(theirs); len(conflicts) > 0 {
+		return &ConflictError{Path: tf.Path, Conflicts: conflicts}
+	}
+	return nil
 }
 
-func uploadWebdavFile(tmp, fn string) {
-	file, _ := os.Open(tmp)
-	defer file.Close()
-
-	c := gowebdav.NewClient(davUrl, davUser, davPassword)
-	c.WriteStream(fn, file, 0644)
+func (tf *TaskFile) setBase(tasks Tasks) {
+	tf.base = make([]baseLine, len(tasks))
+	for i, t := range tasks {
+		tf.base[i] = baseLine{t.LineNumber, t.original}
+	}
 }
@@ -0,0 +1,240 @@
+package tdt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	lines := []string{
+		"(A) 2024-04-01 call mom +family @phone",
+		"x 2024-04-02 2024-04-01 finished",
+		"report due:2024-05-03 t:2024-04-30 rec:+1w est:2",
+	}
+	tf, s := newTestFile(t, lines...)
+	tf.Opts.SortOrder = "priority"
+	tf.Sort()
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fileLines(t, s, "todo.txt"); !equalLines(got, lines) {
+		t.Errorf("file after Write: %q, want %q", got, lines)
+	}
+	again, err := ReadFrom(s, "todo.txt", Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := originals(again); !equalLines(got, lines) {
+		t.Errorf("tasks read back: %q, want %q", got, lines)
+	}
+}
+
+func TestWriteMerges(t *testing.T) {
+	tf, s := newTestFile(t, "one", "two", "three")
+	s.Write("todo.txt", []byte("one\ntwo changed\nthree\nfour\n"))
+	tf.Replace("one changed", 0)
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"one changed", "two changed", "three", "four"}
+	if got := fileLines(t, s, "todo.txt"); !equalLines(got, want) {
+		t.Errorf("file after merge: %q, want %q", got, want)
+	}
+}
+
+func TestWriteConflict(t *testing.T) {
+	tf, s := newTestFile(t, "one", "two")
+	s.Write("todo.txt", []byte("one theirs\ntwo\n"))
+	tf.Replace("one ours", 0)
+	err := tf.Write()
+	var ce *ConflictError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("Write = %v, want a ConflictError", err)
+	}
+	if len(ce.Conflicts) != 1 {
+		t.Fatalf("got %d conflicts, want 1", len(ce.Conflicts))
+	}
+	c := ce.Conflicts[0]
+	if c.Base != "one" || c.Ours != "one ours" || c.Theirs != "one theirs" {
+		t.Errorf("conflict = %+v", c)
+	}
+	tf.Resolve(c, KeepBoth)
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"one ours", "two", "one theirs"}
+	if got := fileLines(t, s, "todo.txt"); !equalLines(got, want) {
+		t.Errorf("file after resolving: %q, want %q", got, want)
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "one", "x 2024-04-30 two", "three")
+	if err := tf.Archive(); err != nil {
+		t.Fatal(err)
+	}
+	tf.Toggle(false, 0)
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	todo := []string{"x 2024-05-01 one", "three"}
+	if got := fileLines(t, s, "todo.txt"); !equalLines(got, todo) {
+		t.Fatalf("file after changes: %q, want %q", got, todo)
+	}
+
+	op, err := tf.Undo()
+	if err != nil || op.Name != "toggle" {
+		t.Fatalf("Undo = %v, %v; want toggle", op.Name, err)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"one", "three"}; !equalLines(got, want) {
+		t.Errorf("file after undoing toggle: %q, want %q", got, want)
+	}
+	if op, err = tf.Undo(); err != nil || op.Name != "archive" {
+		t.Fatalf("Undo = %v, %v; want archive", op.Name, err)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"one", "three", "x 2024-04-30 two"}; !equalLines(got, want) {
+		t.Errorf("file after undoing archive: %q, want %q", got, want)
+	}
+	if got := fileLines(t, s, "done.txt"); len(got) != 0 {
+		t.Errorf("done.txt after undoing archive: %q, want nothing", got)
+	}
+	if _, err = tf.Undo(); err == nil {
+		t.Errorf("Undo with an empty journal succeeded")
+	}
+
+	if op, err = tf.Redo(); err != nil || op.Name != "archive" {
+		t.Fatalf("Redo = %v, %v; want archive", op.Name, err)
+	}
+	if got, want := fileLines(t, s, "done.txt"), []string{"x 2024-04-30 two"}; !equalLines(got, want) {
+		t.Errorf("done.txt after redoing archive: %q, want %q", got, want)
+	}
+
+	// A new change clears what there is left to redo
+	tf.Add("four")
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tf.Redo(); err == nil {
+		t.Errorf("Redo after a new change succeeded")
+	}
+}
+
+func TestUndoConflict(t *testing.T) {
+	tf, s := newTestFile(t, "one")
+	tf.Replace("one edited", 0)
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	s.Write("todo.txt", []byte("one edited elsewhere\n"))
+	tf, err := ReadFrom(s, "todo.txt", Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.Undo(); !errors.Is(err, ErrConflict) {
+		t.Errorf("Undo of a changed line = %v, want a conflict", err)
+	}
+}
+
+func TestUndoFailedWrite(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "one", "x 2024-04-30 two")
+	if err := tf.Archive(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	// The write that brings tf up to date succeeds, the one undoing fails
+	tf.Storage = &failingStorage{s, "todo.txt", 1}
+	if _, err := tf.Undo(); err == nil {
+		t.Fatal("Undo succeeded with a failing write")
+	}
+	if got, want := fileLines(t, s, "done.txt"), []string{"x 2024-04-30 two"}; !equalLines(got, want) {
+		t.Errorf("done.txt after a failed Undo: %q, want %q", got, want)
+	}
+	if got, want := originals(tf), []string{"one"}; !equalLines(got, want) {
+		t.Errorf("tasks after a failed Undo: %q, want %q", got, want)
+	}
+
+	tf.Storage = s
+	if op, err := tf.Undo(); err != nil || op.Name != "archive" {
+		t.Fatalf("Undo after a failed one = %v, %v; want archive", op.Name, err)
+	}
+	if got := fileLines(t, s, "done.txt"); len(got) != 0 {
+		t.Errorf("done.txt after undoing archive: %q, want nothing", got)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"one", "x 2024-04-30 two"}; !equalLines(got, want) {
+		t.Errorf("file after undoing archive: %q, want %q", got, want)
+	}
+}
+
+func TestUndoKeepsNoBackups(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "todo.txt")
+	if err := os.WriteFile(fn, []byte("one\nx 2024-04-30 two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := ReadFrom(&LocalStorage{BackupCount: 3}, fn, Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tf.Archive(); err != nil {
+		t.Fatal(err)
+	}
+	tf.Add("three")
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	// Undoing the archive too edits done.txt
+	for i := 0; i < 2; i++ {
+		if _, err := tf.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tf.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"todo.txt.undo.*", "done.txt.*"} {
+		if found, _ := filepath.Glob(filepath.Join(dir, pattern)); len(found) > 0 {
+			t.Errorf("backups of files gotodotxt keeps itself: %q", found)
+		}
+	}
+	if found, _ := filepath.Glob(filepath.Join(dir, "todo.txt.[0-9]")); len(found) == 0 {
+		t.Errorf("no backups kept of todo.txt")
+	}
+}
+
+func TestUndoSideStorage(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "one", "x 2024-04-30 two")
+	elsewhere := NewMemoryStorage()
+	tf.DoneFile.Storage = elsewhere
+	if err := tf.Archive(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, elsewhere, "done.txt"), []string{"x 2024-04-30 two"}; !equalLines(got, want) {
+		t.Fatalf("done.txt after archive: %q, want %q", got, want)
+	}
+	if _, err := tf.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fileLines(t, elsewhere, "done.txt"); len(got) != 0 {
+		t.Errorf("done.txt after undoing archive: %q, want nothing", got)
+	}
+	if got := fileLines(t, s, "done.txt"); got != nil {
+		t.Errorf("undo wrote done.txt to the task file's storage: %q", got)
+	}
+	if _, err := tf.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, elsewhere, "done.txt"), []string{"x 2024-04-30 two"}; !equalLines(got, want) {
+		t.Errorf("done.txt after redoing archive: %q, want %q", got, want)
+	}
+}
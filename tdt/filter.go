@@ -22,16 +22,15 @@ THE SOFTWARE.
 
 package tdt
 
-import (
-	"sort"
-	"strings"
-	"time"
-)
-
 func (tf *TaskFile) Filter() *TaskFile {
+	today := Today()
 	for i, t := range tf.Tasks {
 		t.FilteredOut = false
-		if !(tf.Opts.ShowFuture || (!t.HasThreshold || t.Threshold.Before(time.Now()))) {
+		t.setDueStatus(today)
+		if !tf.Opts.ShowFuture && t.IsFuture() {
+			t.FilteredOut = true
+		}
+		if !tf.Opts.Query.Match(t) {
 			t.FilteredOut = true
 		}
 		tf.Tasks[i] = t
@@ -44,65 +43,6 @@ func (tf *TaskFile) Sort() *TaskFile {
 	return tf
 }
 
-func (tf *TaskFile) sort(sortOrder string) *TaskFile {
-	sortFields := strings.Fields(
-		strings.ReplaceAll(strings.ToLower(sortOrder), ",", " "))
-	sort.Slice(tf.Tasks, func(i, j int) bool {
-		for _, f := range sortFields {
-			switch f {
-			case "due", "due+", "d", "d+":
-				if tf.Tasks[j].Due.IsZero() {
-					return false
-				}
-				if tf.Tasks[i].Due != tf.Tasks[j].Due {
-					return tf.Tasks[i].Due.After(tf.Tasks[j].Due)
-				}
-			case "due-", "d-":
-				if tf.Tasks[j].Due.IsZero() {
-					return true
-				}
-				if tf.Tasks[i].Due != tf.Tasks[j].Due {
-					return tf.Tasks[i].Due.Before(tf.Tasks[j].Due)
-				}
-			case "threshold", "threshold+", "t", "t+":
-				if tf.Tasks[j].Threshold.IsZero() {
-					return false
-				}
-				if tf.Tasks[i].Threshold != tf.Tasks[j].Threshold {
-					return tf.Tasks[i].Threshold.After(tf.Tasks[j].Threshold)
-				}
-			case "threshold-", "t-":
-				if tf.Tasks[j].Threshold.IsZero() {
-					return true
-				}
-				if tf.Tasks[i].Threshold != tf.Tasks[j].Threshold {
-					return tf.Tasks[i].Threshold.Before(tf.Tasks[j].Threshold)
-				}
-			case "done", "done+", "x", "x+":
-				if tf.Tasks[i].Done != tf.Tasks[j].Done {
-					return tf.Tasks[i].Done < tf.Tasks[j].Done
-				}
-			case "done-", "x-":
-				if tf.Tasks[i].Done != tf.Tasks[j].Done {
-					return tf.Tasks[i].Done > tf.Tasks[j].Done
-				}
-			case "priority", "priority+", "pri", "pri+", "P", "P+":
-				if tf.Tasks[i].Priority == "" {
-					return false
-				}
-				if tf.Tasks[i].Priority != tf.Tasks[j].Priority {
-					return tf.Tasks[i].Priority < tf.Tasks[j].Priority
-				}
-			case "priority-", "pri-", "P-":
-				if tf.Tasks[i].Priority == "" {
-					return false
-				}
-				if tf.Tasks[i].Priority != tf.Tasks[j].Priority {
-					return tf.Tasks[i].Priority > tf.Tasks[j].Priority
-				}
-			}
-		}
-		return tf.Tasks[i].LineNumber < tf.Tasks[j].LineNumber
-	})
-	return tf
+func (t *Task) hasPriority() bool {
+	return t.Priority != "" && t.Priority != "z"
 }
@@ -0,0 +1,154 @@
+package tdt
+
+import (
+	"testing"
+)
+
+// descriptions returns the descriptions of tf's tasks, in order.
+func descriptions(tf *TaskFile) []string {
+	var d []string
+	for _, t := range tf.Tasks {
+		d = append(d, t.Description)
+	}
+	return d
+}
+
+func TestSort(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	lines := []string{
+		"(B) b t:2024-04-02 due:2024-05-03 est:3",
+		"c",
+		"x 2024-04-30 (A) a due:2024-05-01",
+		"(A) d t:2024-04-01 est:10",
+		"e due:2024-05-02 est:x",
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"", []string{"b", "c", "a", "d", "e"}},
+		{"due", []string{"b", "e", "a", "c", "d"}},
+		{"d+", []string{"b", "e", "a", "c", "d"}},
+		{"due-", []string{"a", "e", "b", "c", "d"}},
+		{"d-", []string{"a", "e", "b", "c", "d"}},
+		{"threshold", []string{"b", "d", "c", "a", "e"}},
+		{"t-", []string{"d", "b", "c", "a", "e"}},
+		{"done", []string{"b", "c", "d", "e", "a"}},
+		{"x-", []string{"a", "b", "c", "d", "e"}},
+		{"priority", []string{"a", "d", "b", "c", "e"}},
+		{"p", []string{"a", "d", "b", "c", "e"}},
+		{"pri-", []string{"b", "a", "d", "c", "e"}},
+		{"tag:est", []string{"b", "d", "e", "c", "a"}},
+		{"tag:est-", []string{"e", "d", "b", "c", "a"}},
+		{"done,priority", []string{"d", "b", "c", "e", "a"}},
+		{"x p due-", []string{"d", "b", "e", "c", "a"}},
+		{"unknown", []string{"b", "c", "a", "d", "e"}},
+	}
+	for _, tt := range tests {
+		tf, _ := newTestFile(t, lines...)
+		tf.Opts.SortOrder = tt.order
+		if got := descriptions(tf.Sort()); !equalLines(got, tt.want) {
+			t.Errorf("sort %q = %q, want %q", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestSortPriTag(t *testing.T) {
+	// A completed task's priority can be kept in a pri: tag instead
+	for _, a := range []string{"x 2024-04-30 (A) a", "x 2024-04-30 a pri:A"} {
+		tf, _ := newTestFile(t, "(B) b", a, "c")
+		tf.Opts.SortOrder = "priority"
+		if got, want := descriptions(tf.Sort()), []string{"a", "b", "c"}; !equalLines(got, want) {
+			t.Errorf("sort priority with %q = %q, want %q", a, got, want)
+		}
+	}
+}
+
+func TestSortNewKeys(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	lines := []string{
+		"2024-04-10 step 10 +beta",
+		"x 2024-04-30 2024-04-01 Step 9 @desk",
+		"no dates +alpha @home",
+		"x 2024-04-20 2024-04-25 step 2",
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"created", []string{"d", "a", "b", "c"}},
+		{"created-", []string{"b", "a", "d", "c"}},
+		{"completed", []string{"b", "d", "a", "c"}},
+		{"completed-", []string{"d", "b", "a", "c"}},
+		{"age", []string{"b", "a", "d", "c"}},
+		{"age-", []string{"d", "a", "b", "c"}},
+		{"description", []string{"c", "d", "b", "a"}},
+		{"text-", []string{"a", "b", "d", "c"}},
+		{"project", []string{"c", "a", "b", "d"}},
+		{"project-", []string{"a", "c", "b", "d"}},
+		{"context done", []string{"b", "c", "a", "d"}},
+	}
+	names := map[string]string{
+		"step 10 +beta": "a", "Step 9 @desk": "b", "no dates +alpha @home": "c", "step 2": "d",
+	}
+	for _, tt := range tests {
+		tf, _ := newTestFile(t, lines...)
+		tf.Opts.SortOrder = tt.order
+		var got []string
+		for _, d := range descriptions(tf.Sort()) {
+			got = append(got, names[d])
+		}
+		if !equalLines(got, tt.want) {
+			t.Errorf("sort %q = %q, want %q", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	lines := []string{
+		"overdue due:2024-04-30",
+		"today due:2024-05-01",
+		"later due:2024-05-02",
+		"future t:2024-05-02",
+		"started t:2024-05-01 +work",
+		"x 2024-04-29 done due:2024-04-20 +work",
+	}
+	tf, _ := newTestFile(t, lines...)
+	tf.Filter()
+	var shown []string
+	for _, task := range tf.Tasks {
+		if !task.FilteredOut {
+			shown = append(shown, task.Description)
+		}
+		if task.Overdue != (task.Description == "overdue") {
+			t.Errorf("%q: Overdue = %v", task.Description, task.Overdue)
+		}
+		if task.DueToday != (task.Description == "today") {
+			t.Errorf("%q: DueToday = %v", task.Description, task.DueToday)
+		}
+	}
+	want := []string{"overdue", "today", "later", "started +work", "done +work"}
+	if !equalLines(shown, want) {
+		t.Errorf("Filter showed %q, want %q", shown, want)
+	}
+
+	tf.Opts.ShowFuture = true
+	tf.Opts.Query, _ = ParseQuery("+work")
+	shown = nil
+	for _, task := range tf.Filter().Tasks {
+		if !task.FilteredOut {
+			shown = append(shown, task.Description)
+		}
+	}
+	if want := []string{"started +work", "done +work"}; !equalLines(shown, want) {
+		t.Errorf("Filter +work showed %q, want %q", shown, want)
+	}
+
+	// The due status follows the clock
+	fixClock(t, "2024-05-02")
+	tf.Filter()
+	if !tf.Tasks[1].Overdue || !tf.Tasks[2].DueToday {
+		t.Errorf("due status did not change with the day: %+v", tf.Tasks[1:3])
+	}
+}
@@ -0,0 +1,128 @@
+package tdt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Group is a set of tasks shown under one heading.
+type Group struct {
+	Name      string `json:"name"`
+	TaskCount int    `json:"task_count"`
+	Tasks     Tasks  `json:"tasks"`
+}
+
+// groupKey names a group, and orders it among the others. Tasks with the
+// same order go in the same group.
+type groupKey struct {
+	order string
+	name  string
+}
+
+// groupers maps each Opts.GroupBy value to a function returning the
+// groups a task belongs to. With firstOnly set, a task in several projects
+// or contexts is put under the first only.
+var groupers = map[string]func(t *Task, firstOnly bool) []groupKey{
+	"project": func(t *Task, firstOnly bool) []groupKey {
+		return listGroups(t.Projects, "+", "no project", firstOnly)
+	},
+	"context": func(t *Task, firstOnly bool) []groupKey {
+		return listGroups(t.Contexts, "@", "no context", firstOnly)
+	},
+	"priority": func(t *Task, firstOnly bool) []groupKey {
+		if !t.hasPriority() {
+			return []groupKey{{"~", "no priority"}}
+		}
+		return []groupKey{{t.Priority, "(" + t.Priority + ")"}}
+	},
+	"due": func(t *Task, firstOnly bool) []groupKey {
+		return []groupKey{dueGroup(t, Today())}
+	},
+}
+
+// CheckGroupBy returns an error unless by is a valid Opts.GroupBy value.
+func CheckGroupBy(by string) error {
+	if _, ok := groupers[by]; ok || isFlat(by) {
+		return nil
+	}
+	return fmt.Errorf("unknown grouping %q (use project, context, priority, due or list)", by)
+}
+
+func isFlat(by string) bool {
+	return by == "" || by == "list" || by == "none"
+}
+
+func listGroups(items []string, prefix, none string, firstOnly bool) []groupKey {
+	if len(items) == 0 {
+		return []groupKey{{"~", none}}
+	}
+	if firstOnly {
+		items = items[:1]
+	}
+	var keys []groupKey
+	seen := make(map[string]bool)
+	for _, item := range items {
+		order := strings.ToLower(item)
+		if seen[order] {
+			continue
+		}
+		seen[order] = true
+		keys = append(keys, groupKey{order, prefix + item})
+	}
+	return keys
+}
+
+func dueGroup(t *Task, now time.Time) groupKey {
+	if !t.HasDue {
+		return groupKey{"5", "no due date"}
+	}
+	due := YMD(t.Due)
+	switch {
+	case due < YMD(now):
+		return groupKey{"0", "overdue"}
+	case due == YMD(now):
+		return groupKey{"1", "today"}
+	case due == YMD(now.AddDate(0, 0, 1)):
+		return groupKey{"2", "tomorrow"}
+	case due <= YMD(now.AddDate(0, 0, 7)):
+		return groupKey{"3", "next 7 days"}
+	}
+	return groupKey{"4", "later"}
+}
+
+// Groups splits the tasks that are not filtered out according to
+// tf.Opts.GroupBy, keeping their order within each group. Without a
+// grouping, all the tasks are returned as one unnamed group.
+func (tf *TaskFile) Groups() []Group {
+	grouper, ok := groupers[tf.Opts.GroupBy]
+	if !ok {
+		grouper = func(*Task, bool) []groupKey { return []groupKey{{}} }
+	}
+	var keys []groupKey
+	groups := make(map[string]*Group)
+	for _, t := range tf.Tasks {
+		if t.FilteredOut {
+			continue
+		}
+		for _, k := range grouper(&t, tf.Opts.GroupFirstOnly) {
+			g, ok := groups[k.order]
+			if !ok {
+				g = &Group{Name: k.name}
+				groups[k.order] = g
+				keys = append(keys, k)
+			}
+			g.Tasks = append(g.Tasks, t)
+			g.TaskCount++
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].order < keys[j].order
+	})
+	result := make([]Group, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, *groups[k.order])
+	}
+	return result
+}
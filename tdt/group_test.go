@@ -0,0 +1,67 @@
+package tdt
+
+import (
+	"strings"
+	"testing"
+)
+
+// groupLines returns each group as its name followed by the first word of
+// each of its tasks.
+func groupLines(groups []Group) []string {
+	var lines []string
+	for _, g := range groups {
+		line := g.Name + ":"
+		for _, t := range g.Tasks {
+			line += " " + strings.Fields(t.Description)[0]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestGroups(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	lines := []string{
+		"a +home @phone due:2024-04-30",
+		"b +Work +home due:2024-05-01",
+		"(A) c @desk @phone due:2024-05-02",
+		"d due:2024-05-06",
+		"e +work due:2024-06-01",
+		"f",
+	}
+	tests := []struct {
+		by        string
+		firstOnly bool
+		want      []string
+	}{
+		{"project", false, []string{"+home: a b", "+Work: b e", "no project: c d f"}},
+		{"project", true, []string{"+home: a", "+Work: b e", "no project: c d f"}},
+		{"context", false, []string{"@desk: c", "@phone: a c", "no context: b d e f"}},
+		{"context", true, []string{"@desk: c", "@phone: a", "no context: b d e f"}},
+		{"priority", false, []string{"(A): c", "no priority: a b d e f"}},
+		{"due", false, []string{"overdue: a", "today: b", "tomorrow: c", "next 7 days: d", "later: e", "no due date: f"}},
+		{"", false, []string{": a b c d e f"}},
+	}
+	for _, tt := range tests {
+		tf, _ := newTestFile(t, lines...)
+		tf.Opts.GroupBy = tt.by
+		tf.Opts.GroupFirstOnly = tt.firstOnly
+		got := groupLines(tf.Groups())
+		if !equalLines(got, tt.want) {
+			t.Errorf("group by %q (first only %v) = %q, want %q", tt.by, tt.firstOnly, got, tt.want)
+		}
+	}
+}
+
+func TestGroupsSkipFiltered(t *testing.T) {
+	tf, _ := newTestFile(t, "a +home", "b +home", "c +work")
+	tf.Tasks[1].FilteredOut = true
+	tf.Opts.GroupBy = "project"
+	groups := tf.Groups()
+	if got, want := groupLines(groups), []string{"+home: a", "+work: c"}; !equalLines(got, want) {
+		t.Errorf("groups = %q, want %q", got, want)
+	}
+	if groups[0].TaskCount != 1 {
+		t.Errorf("task count of +home = %d, want 1", groups[0].TaskCount)
+	}
+}
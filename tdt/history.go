@@ -0,0 +1,363 @@
+package tdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxHistory is the number of operations kept for undo.
+var MaxHistory = 100
+
+// Operation is a change made to a task file by one call such as Toggle or
+// Archive, recorded as the lines it removed and added so that it can be
+// undone and redone.
+type Operation struct {
+	Name    string       `json:"name"`
+	Time    time.Time    `json:"time"`
+	Changes []FileChange `json:"changes"`
+}
+
+// FileChange lists the lines an operation removed from and added to one
+// file. Path is empty for the task file itself, and is the done or trash
+// file for lines moved there.
+type FileChange struct {
+	Path    string   `json:"path,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Added   []string `json:"added,omitempty"`
+}
+
+// journal is the undo history of a task file, kept next to it.
+type journal struct {
+	Undo []Operation `json:"undo"`
+	Redo []Operation `json:"redo"`
+}
+
+func journalName(fn string) string {
+	return fn + ".undo"
+}
+
+// record runs f, which changes tf's tasks and returns any changes it made
+// to other files, and adds the result to the history as one operation. It
+// is saved to the journal the next time tf is written.
+func (tf *TaskFile) record(name string, f func() []FileChange) {
+	before := tf.lines()
+	side := f()
+	after := tf.lines()
+	var c FileChange
+	for _, ln := range sortedKeys(before) {
+		if line, ok := after[ln]; !ok || line != before[ln] {
+			c.Removed = append(c.Removed, before[ln])
+		}
+	}
+	for _, ln := range sortedKeys(after) {
+		if line, ok := before[ln]; !ok || line != after[ln] {
+			c.Added = append(c.Added, after[ln])
+		}
+	}
+	op := Operation{Name: name, Time: now()}
+	if len(c.Removed) > 0 || len(c.Added) > 0 {
+		op.Changes = append(op.Changes, c)
+	}
+	op.Changes = append(op.Changes, side...)
+	if len(op.Changes) > 0 {
+		tf.history = append(tf.history, op)
+	}
+}
+
+func (tf *TaskFile) lines() map[int]string {
+	lines := make(map[int]string, len(tf.Tasks))
+	for _, t := range tf.Tasks {
+		lines[t.LineNumber] = t.original
+	}
+	return lines
+}
+
+func sortedKeys(m map[int]string) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func (tf *TaskFile) readJournal() (*journal, error) {
+	fn := journalName(tf.Path)
+	data, _, err := tf.Storage.Read(fn)
+	if isNotFound(err) {
+		return &journal{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var j journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, newFileError("read", fn, fmt.Errorf("%w: %v", ErrParse, err))
+	}
+	return &j, nil
+}
+
+// saveHistory adds the operations recorded since tf was last written to
+// the journal, or replaces it after an undo or redo. It is called by write
+// while the task file is locked.
+func (tf *TaskFile) saveHistory() error {
+	j := tf.journal
+	if j == nil {
+		if len(tf.history) == 0 {
+			return nil
+		}
+		var err error
+		if j, err = tf.readJournal(); err != nil {
+			return err
+		}
+		j.Undo = append(j.Undo, tf.history...)
+		j.Redo = nil
+	}
+	if len(j.Undo) > MaxHistory {
+		j.Undo = j.Undo[len(j.Undo)-MaxHistory:]
+	}
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := writeWithoutBackup(tf.Storage, journalName(tf.Path), data); err != nil {
+		return err
+	}
+	tf.history = nil
+	tf.journal = nil
+	return nil
+}
+
+// snapshot is what an operation that writes tf puts back if the write
+// fails. That includes what the failed write merged from the file, which
+// the next write merges again.
+type snapshot struct {
+	tasks      Tasks
+	base       []baseLine
+	lastUpdate time.Time
+	history    int
+}
+
+func (tf *TaskFile) snapshot() snapshot {
+	return snapshot{append(Tasks(nil), tf.Tasks...), tf.base, tf.LastUpdate, len(tf.history)}
+}
+
+func (tf *TaskFile) rollback(s snapshot) {
+	tf.Tasks, tf.base, tf.LastUpdate = s.tasks, s.base, s.lastUpdate
+	tf.history = tf.history[:s.history]
+}
+
+// Undo reverts the most recent operation in the journal and writes the
+// file. It fails with ErrConflict if the lines the operation added have
+// been changed since.
+func (tf *TaskFile) Undo() (Operation, error) {
+	return tf.replay(true)
+}
+
+// Redo applies again the operation most recently undone.
+func (tf *TaskFile) Redo() (Operation, error) {
+	return tf.replay(false)
+}
+
+func (tf *TaskFile) replay(undo bool) (Operation, error) {
+	// The lock is held from reading the journal to writing it back, so
+	// that an operation another process records meanwhile is not lost
+	unlock, err := tf.lock()
+	if err != nil {
+		return Operation{}, err
+	}
+	defer unlock()
+	// Changes made elsewhere are merged first, so that any conflict with
+	// them is settled before the operation is replayed
+	if err := tf.Write(); err != nil {
+		return Operation{}, err
+	}
+	j, err := tf.readJournal()
+	if err != nil {
+		return Operation{}, err
+	}
+	from, to := &j.Undo, &j.Redo
+	if !undo {
+		from, to = to, from
+	}
+	if len(*from) == 0 {
+		if undo {
+			return Operation{}, fmt.Errorf("nothing to undo")
+		}
+		return Operation{}, fmt.Errorf("nothing to redo")
+	}
+	op := (*from)[len(*from)-1]
+	lines := func(c FileChange) (remove, add []string) {
+		if undo {
+			return c.Added, c.Removed
+		}
+		return c.Removed, c.Added
+	}
+	saved, base, lastUpdate := append(Tasks(nil), tf.Tasks...), tf.base, tf.LastUpdate
+	for _, c := range op.Changes {
+		remove, add := lines(c)
+		if c.Path == "" {
+			err = tf.replaceLines(remove, add)
+		} else {
+			err = checkLines(tf.storageFor(c.Path), c.Path, remove)
+		}
+		if err != nil {
+			tf.Tasks = saved
+			return op, err
+		}
+	}
+	// Lines are added to the done and trash files before the task file is
+	// written, and removed from them after, so that a failure part way
+	// leaves a task in two files rather than in none.
+	for _, c := range op.Changes {
+		if _, add := lines(c); c.Path != "" && len(add) > 0 {
+			if err := editLines(tf.storageFor(c.Path), c.Path, nil, add); err != nil {
+				tf.Tasks = saved
+				return op, err
+			}
+		}
+	}
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, op)
+	tf.journal = j
+	if err := tf.Write(); err != nil {
+		// The journal only moves once the file is written
+		tf.Tasks, tf.base, tf.LastUpdate, tf.journal = saved, base, lastUpdate, nil
+		for _, c := range op.Changes {
+			if _, add := lines(c); c.Path != "" && len(add) > 0 {
+				if err := editLines(tf.storageFor(c.Path), c.Path, add, nil); err != nil {
+					Log(log.Error, "could not take back lines added to "+c.Path+": "+err.Error())
+				}
+			}
+		}
+		return op, err
+	}
+	for _, c := range op.Changes {
+		if remove, _ := lines(c); c.Path != "" && len(remove) > 0 {
+			if err := editLines(tf.storageFor(c.Path), c.Path, remove, nil); err != nil {
+				return op, err
+			}
+		}
+	}
+	return op, nil
+}
+
+// storageFor returns the storage holding fn, which is the task file or
+// its done or trash file.
+func (tf *TaskFile) storageFor(fn string) Storage {
+	for _, side := range []*TaskFile{tf.DoneFile, tf.TrashFile} {
+		if side != nil && side.Path == fn {
+			return side.Storage
+		}
+	}
+	return tf.Storage
+}
+
+// replaceLines removes the tasks with the text of each line in remove, and
+// adds the tasks in add. Added tasks take the places of removed ones, so
+// that undoing an edit leaves the task where it was.
+func (tf *TaskFile) replaceLines(remove, add []string) error {
+	used := make(map[int]bool)
+	var places []int
+	for _, line := range remove {
+		found := -1
+		for i, t := range tf.Tasks {
+			if !used[i] && t.original == line {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return fmt.Errorf("%w: %q has changed since", ErrConflict, line)
+		}
+		used[found] = true
+		places = append(places, tf.Tasks[found].LineNumber)
+	}
+	var kept Tasks
+	for i, t := range tf.Tasks {
+		if !used[i] {
+			kept = append(kept, t)
+		}
+	}
+	tf.Tasks = kept
+	for i, line := range add {
+		t, err := parseTask(line)
+		if err != nil {
+			return &ParseError{Text: line, Err: err}
+		}
+		if i < len(places) {
+			t.LineNumber = places[i]
+		} else {
+			t.LineNumber = tf.nextLineNumber()
+		}
+		tf.Tasks = append(tf.Tasks, t)
+	}
+	return nil
+}
+
+// editLines removes each line in remove from fn, starting from the end,
+// and appends the lines in add.
+func editLines(s Storage, fn string, remove, add []string) error {
+	if err := s.Lock(fn); err != nil {
+		return err
+	}
+	defer s.Unlock(fn)
+	lines, err := readLines(s, fn)
+	if err != nil {
+		return err
+	}
+	if lines, err = removeLines(fn, lines, remove); err != nil {
+		return err
+	}
+	lines = append(lines, add...)
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+	return writeWithoutBackup(s, fn, buf.Bytes())
+}
+
+// checkLines returns an error unless fn holds each line in remove.
+func checkLines(s Storage, fn string, remove []string) error {
+	lines, err := readLines(s, fn)
+	if err != nil {
+		return err
+	}
+	_, err = removeLines(fn, lines, remove)
+	return err
+}
+
+// readLines returns the lines of fn, which may not exist.
+func readLines(s Storage, fn string) ([]string, error) {
+	data, _, err := s.Read(fn)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// removeLines removes each line in remove from lines, starting from the
+// end. It fails with ErrConflict if one of them isn't there.
+func removeLines(fn string, lines, remove []string) ([]string, error) {
+	for _, line := range remove {
+		found := -1
+		for i := len(lines) - 1; i >= 0; i-- {
+			if lines[i] == line {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return nil, &FileError{Op: "undo", Path: fn, Kind: ErrConflict,
+				Err: fmt.Errorf("%w: %q is no longer there", ErrConflict, line)}
+		}
+		lines = append(lines[:found], lines[found+1:]...)
+	}
+	return lines, nil
+}
@@ -0,0 +1,77 @@
+package tdt
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	idLetters = "abcdefghjkmnpqrstuvwxyz"
+	idChars   = idLetters + "23456789"
+	idLength  = 5
+)
+
+var randRead = rand.Read
+
+// newID returns an identifier not used by any task in tf. IDs start with
+// a letter so they can't be mistaken for line numbers.
+func (tf *TaskFile) newID() string {
+	for {
+		b := make([]byte, idLength)
+		if _, err := randRead(b); err != nil {
+			// Fall back to the clock, which is unique enough given that the
+			// id is checked against the others below
+			Log(log.Warning, "no random source for ids: "+err.Error())
+			n := time.Now().UnixNano()
+			for i := range b {
+				b[i] = byte(n >> (8 * i))
+			}
+		}
+		id := string(idLetters[int(b[0])%len(idLetters)])
+		for _, c := range b[1:] {
+			id += string(idChars[int(c)%len(idChars)])
+		}
+		if i, _ := tf.findID(id); i < 0 {
+			return id
+		}
+	}
+}
+
+func (tf *TaskFile) findID(id string) (int, Task) {
+	for i, t := range tf.Tasks {
+		if t.ID != "" && t.ID == id {
+			return i, t
+		}
+	}
+	return -1, Task{}
+}
+
+// setID adds an id tag to t, or replaces the one it has.
+func setID(t *Task, id string) {
+	t.setTag(TokenID, "id", id)
+}
+
+// LineNumbers turns task references into line numbers. A reference is
+// either a line number or a task's id, optionally written as id:ID.
+func (tf *TaskFile) LineNumbers(refs ...string) ([]int, error) {
+	var nums []int
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if num, err := strconv.Atoi(ref); err == nil {
+			if i, _ := tf.findTask(num); i < 0 {
+				return nil, fmt.Errorf("no task on line %d: %w", num, ErrNotFound)
+			}
+			nums = append(nums, num)
+			continue
+		}
+		i, t := tf.findID(strings.TrimPrefix(ref, "id:"))
+		if i < 0 {
+			return nil, fmt.Errorf("no task with id %q: %w", ref, ErrNotFound)
+		}
+		nums = append(nums, t.LineNumber)
+	}
+	return nums, nil
+}
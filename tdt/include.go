@@ -0,0 +1,108 @@
+package tdt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The files other than the tasks file that tasks can be listed from.
+const (
+	SourceDone  = "done"
+	SourceTrash = "trash"
+)
+
+// CheckInclude returns an error unless each of include is a valid
+// Opts.Include value.
+func CheckInclude(include []string) error {
+	for _, source := range include {
+		if source != SourceDone && source != SourceTrash {
+			return fmt.Errorf("unknown file to include %q (use %s or %s)", source, SourceDone, SourceTrash)
+		}
+	}
+	return nil
+}
+
+// WithIncluded returns a copy of tf that also holds the tasks of the files
+// named by tf.Opts.Include, with their Source set. The copy is for
+// listing: it is never written, and its tasks can't be changed through it.
+func (tf *TaskFile) WithIncluded() (*TaskFile, error) {
+	view := &TaskFile{
+		Path:       tf.Path,
+		Storage:    tf.Storage,
+		DoneFile:   tf.DoneFile,
+		TrashFile:  tf.TrashFile,
+		Opts:       tf.Opts,
+		Tasks:      append(Tasks(nil), tf.Tasks...),
+		LastUpdate: tf.LastUpdate,
+	}
+	for _, source := range tf.Opts.Include {
+		tasks, err := tf.readSource(source)
+		if err != nil {
+			return nil, err
+		}
+		view.Tasks = append(view.Tasks, tasks...)
+	}
+	return view, nil
+}
+
+// sourceFile returns the done or trash file of tf.
+func (tf *TaskFile) sourceFile(source string) *TaskFile {
+	if source == SourceTrash {
+		return tf.TrashFile
+	}
+	return tf.DoneFile
+}
+
+// readSource reads the tasks of the done or trash file.
+func (tf *TaskFile) readSource(source string) (Tasks, error) {
+	side := tf.sourceFile(source)
+	if side == nil {
+		return nil, nil
+	}
+	data, _, err := side.Storage.Read(side.Path)
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	tasks, err := readTasks(data)
+	if err != nil {
+		return nil, newFileError("read", side.Path, err)
+	}
+	for i := range tasks {
+		tasks[i].Source = source
+	}
+	return tasks, nil
+}
+
+// SourceLineNumbers turns references to tasks in the done or trash file
+// into their line numbers there, as LineNumbers does for tf. A line number
+// can also be written as listed with Opts.Include, as in d3 or t0.
+func (tf *TaskFile) SourceLineNumbers(source string, refs ...string) ([]int, error) {
+	if err := CheckInclude([]string{source}); err != nil {
+		return nil, err
+	}
+	tasks, err := tf.readSource(source)
+	if err != nil {
+		return nil, err
+	}
+	side := &TaskFile{Tasks: tasks}
+	for i, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if n := strings.TrimPrefix(ref, source[:1]); n != ref && n != "" && isDigit(n[0]) {
+			ref = n
+		}
+		refs[i] = ref
+	}
+	return side.LineNumbers(refs...)
+}
+
+// includes reports whether tf.Opts.Include names source.
+func (tf *TaskFile) includes(source string) bool {
+	for _, s := range tf.Opts.Include {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package tdt
+
+import (
+	"testing"
+)
+
+func TestWithIncluded(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "open +work", "x 2024-04-30 closed")
+	s.Write("done.txt", []byte("x 2024-04-01 archived +work\n\nx 2024-04-02 archived too\n"))
+	s.Write("trash.txt", []byte("thrown away +work\n"))
+
+	view, err := tf.WithIncluded()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Tasks) != 2 {
+		t.Errorf("with nothing included, got %q", originals(view))
+	}
+
+	tf.Opts.Include = []string{SourceDone, SourceTrash}
+	tf.Opts.Query, _ = ParseQuery("+work")
+	if view, err = tf.WithIncluded(); err != nil {
+		t.Fatal(err)
+	}
+	if n := view.Tasks[len(view.Tasks)-2]; n.Source != SourceDone || n.LineNumber != 1 {
+		t.Errorf("second done task is %q line %d, want done line 1", n.Source, n.LineNumber)
+	}
+	var got []string
+	for _, task := range view.Sort().Filter().Tasks {
+		if !task.FilteredOut {
+			got = append(got, task.Source+":"+task.Description)
+		}
+	}
+	want := []string{":open +work", "done:archived +work", "trash:thrown away +work"}
+	if !equalLines(got, want) {
+		t.Errorf("listed %q, want %q", got, want)
+	}
+	if len(tf.Tasks) != 2 {
+		t.Errorf("included tasks were added to the file itself: %q", originals(tf))
+	}
+
+	for query, want := range map[string]int{"in:done": 2, "source:trash": 1, "in:todo": 2, "in:!=todo": 3} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, task := range view.Tasks {
+			if q.Match(task) {
+				n++
+			}
+		}
+		if n != want {
+			t.Errorf("%q matched %d tasks, want %d", query, n, want)
+		}
+	}
+	if _, err := ParseQuery("in:attic"); err == nil {
+		t.Errorf("in:attic parsed")
+	}
+}
+
+func TestCheckInclude(t *testing.T) {
+	if err := CheckInclude([]string{SourceDone, SourceTrash}); err != nil {
+		t.Error(err)
+	}
+	if err := CheckInclude([]string{"attic"}); err == nil {
+		t.Error("CheckInclude accepted attic")
+	}
+}
@@ -0,0 +1,213 @@
+package tdt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+var (
+	upperDoneRegex     = regexp.MustCompile(`^X(\s+\d{4}-\d\d-\d\d\s)`)
+	lowerPriorityRegex = regexp.MustCompile(`^((?:x\s+(?:\d{4}-\d\d-\d\d\s+)?)?)\(([a-z])\)(\s|$)`)
+	priorityNoSpace    = regexp.MustCompile(`^((?:x\s+(?:\d{4}-\d\d-\d\d\s+)?)?)(\([A-Z]\))(\S)`)
+	donePriorityRegex  = regexp.MustCompile(`^(x\s+\d{4}-\d\d-\d\d)\s+\(([A-Z])\)(\s+|$)`)
+	priTagRegex        = regexp.MustCompile(`(^|\s)pri:\S`)
+)
+
+// Problem is a line of a task file that doesn't follow the todo.txt
+// format. Line counts from zero, including blank lines.
+type Problem struct {
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Reason  string `json:"reason"`
+	Fixable bool   `json:"fixable"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("line %d: %s", p.Line+1, p.Reason)
+}
+
+// lintRule is one check made by Lint. fix is nil if the problem can't be
+// fixed without knowing more than the line says.
+type lintRule struct {
+	reason string
+	check  func(line string) bool
+	fix    func(line string) string
+}
+
+// lintRules are applied in order, so each fix sees the line as fixed by
+// the ones before it.
+var lintRules = []lintRule{
+	{
+		reason: "leading space: the x or priority must start the line",
+		check:  func(line string) bool { return line != strings.TrimLeft(line, " \t") },
+		fix:    func(line string) string { return strings.TrimLeft(line, " \t") },
+	},
+	{
+		reason: "trailing space",
+		check:  func(line string) bool { return line != strings.TrimRight(line, " \t") },
+		fix:    func(line string) string { return strings.TrimRight(line, " \t") },
+	},
+	{
+		reason: "completion mark is an uppercase X",
+		check:  upperDoneRegex.MatchString,
+		fix:    func(line string) string { return upperDoneRegex.ReplaceAllString(line, "x$1") },
+	},
+	{
+		reason: "priority is a lowercase letter",
+		check:  lowerPriorityRegex.MatchString,
+		fix: func(line string) string {
+			m := lowerPriorityRegex.FindStringSubmatchIndex(line)
+			return line[:m[4]] + strings.ToUpper(line[m[4]:m[5]]) + line[m[5]:]
+		},
+	},
+	{
+		reason: "no space after the priority",
+		check:  priorityNoSpace.MatchString,
+		fix:    func(line string) string { return priorityNoSpace.ReplaceAllString(line, "$1$2 $3") },
+	},
+	{
+		reason: "priority after the completion date (use a pri: tag)",
+		check:  donePriorityRegex.MatchString,
+		fix: func(line string) string {
+			p := donePriorityRegex.FindStringSubmatch(line)[2]
+			line = donePriorityRegex.ReplaceAllString(line, "$1$3")
+			if !priTagRegex.MatchString(line) {
+				line = strings.TrimRight(line, " \t") + " pri:" + p
+			}
+			return line
+		},
+	},
+	{
+		// The format allows this, but it isn't read as completed unless
+		// Strict is set
+		reason: "completed without a completion date",
+		check: func(line string) bool {
+			f := strings.Fields(line)
+			is := func(i int, re *regexp.Regexp) bool { return i < len(f) && re.MatchString(f[i]) }
+			return !Strict && len(f) > 0 && f[0] == "x" && !is(1, dateTokenRegex) &&
+				!(is(1, priorityTokenRegex) && is(2, dateTokenRegex))
+		},
+	},
+	{
+		reason: "relative due or threshold date",
+		check: func(line string) bool {
+			t, err := parseLine(line, true)
+			return err == nil && t.hasRelativeDates()
+		},
+		fix: func(line string) string {
+			t, err := parseNewTask(line)
+			if err != nil {
+				return line
+			}
+			return t.original
+		},
+	},
+	{
+		reason: "completion date but no creation date",
+		check: func(line string) bool {
+			t, err := parseTask(line)
+			return err == nil && t.findToken(TokenCompleted) >= 0 && t.findToken(TokenCreated) < 0
+		},
+	},
+	{
+		reason: "completed before it was created",
+		check: func(line string) bool {
+			t, err := parseTask(line)
+			return err == nil && t.IsDone() && !t.Created.IsZero() && t.Completed.Before(t.Created)
+		},
+	},
+}
+
+// hasRelativeDates reports whether the due or threshold date is written
+// as something other than a date, such as due:fri.
+func (t *Task) hasRelativeDates() bool {
+	for _, tok := range t.tokens {
+		if tok.Kind == TokenDue || tok.Kind == TokenThreshold {
+			if _, err := parseYMD(tok.Value); err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LintLine returns the problems with a line of a task file. Each is
+// found in the line as fixed by the rules before it, so one problem
+// doesn't hide another.
+func LintLine(line string) []Problem {
+	problems, _ := lint(line)
+	return problems
+}
+
+// FixLine returns line with the problems that can be fixed fixed.
+func FixLine(line string) string {
+	_, fixed := lint(line)
+	return fixed
+}
+
+func lint(line string) ([]Problem, string) {
+	if strings.TrimSpace(line) == "" {
+		return nil, line
+	}
+	if _, err := parseTask(line); err != nil {
+		return []Problem{{Text: line, Reason: err.Error()}}, line
+	}
+	var problems []Problem
+	fixed := line
+	for _, r := range lintRules {
+		if !r.check(fixed) {
+			continue
+		}
+		problems = append(problems, Problem{Text: line, Reason: r.reason, Fixable: r.fix != nil})
+		if r.fix != nil {
+			fixed = r.fix(fixed)
+		}
+	}
+	return problems, fixed
+}
+
+// Lint returns the problems with each line of data.
+func Lint(data []byte) []Problem {
+	var problems []Problem
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 0; scanner.Scan(); n++ {
+		for _, p := range LintLine(scanner.Text()) {
+			p.Line = n
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
+
+// LintFrom returns the problems with fn in s.
+func LintFrom(s Storage, fn string) ([]Problem, error) {
+	fn, err := homedir.Expand(fn)
+	if err != nil {
+		return nil, newFileError("read", fn, err)
+	}
+	data, _, err := s.Read(fn)
+	if err != nil {
+		return nil, err
+	}
+	return Lint(data), nil
+}
+
+// Fix fixes the problems in tf's tasks that can be fixed, as FixLine
+// does. Like any other change, it is saved by Write and can be undone.
+func (tf *TaskFile) Fix() *TaskFile {
+	tf.record("validate", func() []FileChange {
+		for i, t := range tf.Tasks {
+			if fixed := FixLine(t.original); fixed != t.original {
+				t.reparse(fixed)
+				tf.Tasks[i] = t
+			}
+		}
+		return nil
+	})
+	return tf
+}
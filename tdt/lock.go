@@ -0,0 +1,151 @@
+package tdt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultLockTimeout is how long to wait for another process to
+	// release a lock when a storage does not set its own timeout.
+	DefaultLockTimeout = 5 * time.Second
+	// staleLockAge is the age after which a lock is assumed to have been
+	// left behind by a process that died. Locks are only held while a file
+	// is being written, so this can be short.
+	staleLockAge = 30 * time.Second
+	lockRetry    = 50 * time.Millisecond
+)
+
+func lockName(fn string) string {
+	return fn + ".lock"
+}
+
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s %d %s", host, os.Getpid(), time.Now().Format(time.RFC3339Nano))
+}
+
+// lockOwners records the owner written into each lock a storage holds, so
+// that a lock taken over as stale by another process is not removed when
+// this one unlocks.
+type lockOwners struct {
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+func (l *lockOwners) set(fn, owner string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owners == nil {
+		l.owners = make(map[string]string)
+	}
+	l.owners[fn] = owner
+}
+
+// take forgets the owner of the lock on fn and returns it.
+func (l *lockOwners) take(fn string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	owner := l.owners[fn]
+	delete(l.owners, fn)
+	return owner
+}
+
+// waitForLock calls try until it reports the lock was taken, it fails, or
+// timeout passes.
+func waitForLock(fn string, timeout time.Duration, try func() (bool, error)) error {
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := try()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &FileError{Op: "lock", Path: fn, Kind: ErrLocked,
+				Err: fmt.Errorf("%w: gave up after %s (if no other gotodotxt is running, remove %s)",
+					ErrLocked, timeout, lockName(fn))}
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+func (s *LocalStorage) Lock(fn string) error {
+	name := lockName(fn)
+	owner := lockOwner()
+	return waitForLock(fn, s.LockTimeout, func() (bool, error) {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(owner + "\n")
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				// A lock without its owner could not be unlocked
+				os.Remove(name)
+				return false, newFileError("lock", name, err)
+			}
+			s.locks.set(fn, owner)
+			return true, nil
+		}
+		if !os.IsExist(err) {
+			return false, newFileError("lock", name, err)
+		}
+		return false, removeStaleLock(name)
+	})
+}
+
+// removeStaleLock removes the lock name if it is stale. It is first
+// renamed aside, so that of several processes finding the same stale lock
+// only one removes it, and none removes a lock another has just taken.
+func removeStaleLock(name string) error {
+	info, err := os.Stat(name)
+	if err != nil || time.Since(info.ModTime()) <= staleLockAge {
+		return nil
+	}
+	aside := fmt.Sprintf("%s.%d.%d", name, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(name, aside); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return newFileError("lock", name, err)
+	}
+	if info, err := os.Stat(aside); err == nil && time.Since(info.ModTime()) <= staleLockAge {
+		// Another process took the lock over since it was checked; give
+		// it back, unless a third has taken it meanwhile
+		if err := os.Link(aside, name); err != nil {
+			Log(log.Warning, "could not give back lock "+name+": "+err.Error())
+		}
+	} else {
+		Log(log.Warning, "removed stale lock "+name)
+	}
+	return newFileError("lock", aside, os.Remove(aside))
+}
+
+func (s *LocalStorage) Unlock(fn string) error {
+	name := lockName(fn)
+	owner := s.locks.take(fn)
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return newFileError("unlock", name, err)
+	}
+	if strings.TrimSpace(string(data)) != owner {
+		// the lock was taken over as stale
+		Log(log.Warning, "not removing "+name+", which another process has taken over")
+		return nil
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return newFileError("unlock", name, err)
+}
@@ -0,0 +1,142 @@
+package tdt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockHeld(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "todo.txt")
+	ours := &LocalStorage{}
+	theirs := &LocalStorage{LockTimeout: 100 * time.Millisecond}
+	if err := ours.Lock(fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.Lock(fn); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock of a held lock = %v, want ErrLocked", err)
+	}
+	if err := ours.Unlock(fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.Lock(fn); err != nil {
+		t.Fatalf("Lock after Unlock = %v", err)
+	}
+	theirs.Unlock(fn)
+	if _, err := os.Stat(lockName(fn)); !os.IsNotExist(err) {
+		t.Errorf("lock file left after Unlock: %v", err)
+	}
+}
+
+func TestLockStale(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "todo.txt")
+	name := lockName(fn)
+	if err := os.WriteFile(name, []byte("elsewhere 1 then\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * staleLockAge)
+	os.Chtimes(name, old, old)
+	s := &LocalStorage{LockTimeout: time.Second}
+	if err := s.Lock(fn); err != nil {
+		t.Fatalf("Lock over a stale lock = %v", err)
+	}
+	defer s.Unlock(fn)
+	data, _ := os.ReadFile(name)
+	if strings.HasPrefix(string(data), "elsewhere") {
+		t.Errorf("stale lock was not taken over: %q", data)
+	}
+}
+
+func TestLockStaleRace(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "todo.txt")
+	name := lockName(fn)
+	if err := os.WriteFile(name, []byte("elsewhere 1 then\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * staleLockAge)
+	os.Chtimes(name, old, old)
+	// All the waiters find the same stale lock, but only one at a time
+	// may hold it
+	var holders, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := &LocalStorage{LockTimeout: 5 * time.Second}
+			if err := s.Lock(fn); err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&holders, 1)
+			if n > atomic.LoadInt32(&most) {
+				atomic.StoreInt32(&most, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			s.Unlock(fn)
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Errorf("%d processes held the lock at once", most)
+	}
+	if found, _ := filepath.Glob(name + ".*"); len(found) > 0 {
+		t.Errorf("stale locks left behind: %q", found)
+	}
+}
+
+func TestUnlockChecksOwner(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "todo.txt")
+	name := lockName(fn)
+	ours := &LocalStorage{}
+	if err := ours.Lock(fn); err != nil {
+		t.Fatal(err)
+	}
+	// Another process decides the lock is stale and takes it over
+	old := time.Now().Add(-2 * staleLockAge)
+	os.Chtimes(name, old, old)
+	theirs := &LocalStorage{LockTimeout: time.Second}
+	if err := theirs.Lock(fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := ours.Unlock(fn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("Unlock removed a lock taken over by another process: %v", err)
+	}
+	if err := theirs.Unlock(fn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("lock file left after its owner unlocked: %v", err)
+	}
+}
+
+func TestUndoHoldsLock(t *testing.T) {
+	tf, s := newTestFile(t, "one")
+	tf.Replace("one edited", 0)
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	s.LockTimeout = 100 * time.Millisecond
+	if err := s.Lock("todo.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.Undo(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Undo of a locked file = %v, want ErrLocked", err)
+	}
+	s.Unlock("todo.txt")
+	if _, err := tf.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"one"}; !equalLines(got, want) {
+		t.Errorf("file after Undo: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package tdt
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps task files in memory. It is meant for tests.
+type MemoryStorage struct {
+	LockTimeout time.Duration
+	mu          sync.Mutex
+	files       map[string]memoryFile
+	watchers    map[string][]chan struct{}
+	locks       map[string]bool
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files:    make(map[string]memoryFile),
+		watchers: make(map[string][]chan struct{}),
+		locks:    make(map[string]bool),
+	}
+}
+
+func (s *MemoryStorage) Read(fn string) ([]byte, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[fn]
+	if !ok {
+		return nil, time.Time{}, newFileError("read", fn, os.ErrNotExist)
+	}
+	return append([]byte(nil), f.data...), f.modTime, nil
+}
+
+func (s *MemoryStorage) Write(fn string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(fn, append([]byte(nil), data...))
+	return nil
+}
+
+func (s *MemoryStorage) Append(fn string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing := s.files[fn].data
+	s.store(fn, append(append([]byte(nil), existing...), data...))
+	return nil
+}
+
+func (s *MemoryStorage) Stat(fn string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[fn]
+	if !ok {
+		return time.Time{}, newFileError("stat", fn, os.ErrNotExist)
+	}
+	return f.modTime, nil
+}
+
+func (s *MemoryStorage) Watch(fn string, changed chan<- FileChangedEvent, stop <-chan struct{}) error {
+	notify := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.watchers[fn] = append(s.watchers[fn], notify)
+	s.mu.Unlock()
+	defer s.unwatch(fn, notify)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-notify:
+			select {
+			case changed <- FileChangedEvent{EventName: "memory"}:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+func (s *MemoryStorage) Lock(fn string) error {
+	return waitForLock(fn, s.LockTimeout, func() (bool, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.locks[fn] {
+			return false, nil
+		}
+		s.locks[fn] = true
+		return true, nil
+	})
+}
+
+func (s *MemoryStorage) Unlock(fn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locks, fn)
+	return nil
+}
+
+// store must be called with s.mu held.
+func (s *MemoryStorage) store(fn string, data []byte) {
+	modTime := time.Now()
+	if f, ok := s.files[fn]; ok && !modTime.After(f.modTime) {
+		modTime = f.modTime.Add(time.Nanosecond)
+	}
+	s.files[fn] = memoryFile{data: data, modTime: modTime}
+	for _, notify := range s.watchers[fn] {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *MemoryStorage) unwatch(fn string, notify chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watchers := s.watchers[fn]
+	for i, w := range watchers {
+		if w == notify {
+			s.watchers[fn] = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package tdt
+
+import "fmt"
+
+// baseLine is a line of the file as it was last read or written, along
+// with the line number of the task it was parsed into.
+type baseLine struct {
+	LineNumber int
+	Text       string
+}
+
+// Conflict is a task that was changed both in memory and in the file
+// since it was read. An empty Ours or Theirs means that side deleted it.
+type Conflict struct {
+	LineNumber int
+	Base       string
+	Ours       string
+	Theirs     string
+}
+
+// ConflictError is returned by Write when the file was changed elsewhere
+// and the changes could not all be merged. Everything that could be merged
+// has been; the conflicting tasks hold our version until resolved.
+type ConflictError struct {
+	Path      string
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %d conflicting changes", e.Path, len(e.Conflicts))
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+type Resolution int
+
+const (
+	KeepOurs Resolution = iota
+	KeepTheirs
+	KeepBoth
+)
+
+// Resolve settles a conflict returned by Write. The file still needs to
+// be written afterwards.
+func (tf *TaskFile) Resolve(c Conflict, r Resolution) *TaskFile {
+	if r == KeepOurs || c.Theirs == "" && r == KeepBoth {
+		return tf
+	}
+	theirs, err := parseTask(c.Theirs)
+	if c.Theirs != "" && err != nil {
+		return tf
+	}
+	i, _ := tf.findTask(c.LineNumber)
+	switch {
+	case r == KeepTheirs && c.Theirs == "":
+		if i >= 0 {
+			tf.Tasks = append(tf.Tasks[:i], tf.Tasks[i+1:]...)
+		}
+	case r == KeepTheirs && i >= 0:
+		theirs.LineNumber = c.LineNumber
+		tf.Tasks[i] = theirs
+	default:
+		theirs.LineNumber = tf.nextLineNumber()
+		tf.Tasks = append(tf.Tasks, theirs)
+	}
+	return tf
+}
+
+// merge brings changes made to the file elsewhere into tf. theirs is the
+// file as it is now. Changes to different tasks are combined; a task
+// changed differently on both sides is a conflict, and keeps our version.
+// Afterwards theirs is the new base.
+func (tf *TaskFile) merge(theirs Tasks) []Conflict {
+	base := tf.base
+	baseText := make([]string, len(base))
+	for i, b := range base {
+		baseText[i] = b.Text
+	}
+	theirText := make([]string, len(theirs))
+	for i, t := range theirs {
+		theirText[i] = t.original
+	}
+
+	// Line up their file with the base, treating a deletion followed by an
+	// insertion in the same place as an edit of that line.
+	theirsOf := make(map[int]int)
+	var added []int
+	var dels, ins []int
+	flush := func() {
+		for k, i := range dels {
+			if k < len(ins) {
+				theirsOf[i] = ins[k]
+			}
+		}
+		if len(ins) > len(dels) {
+			added = append(added, ins[len(dels):]...)
+		}
+		dels, ins = nil, nil
+	}
+	for _, op := range diff(baseText, theirText) {
+		switch {
+		case op.a >= 0 && op.b >= 0:
+			flush()
+			theirsOf[op.a] = op.b
+		case op.a >= 0:
+			dels = append(dels, op.a)
+		default:
+			ins = append(ins, op.b)
+		}
+	}
+	flush()
+
+	ours := make(map[int]int)
+	for i, t := range tf.Tasks {
+		ours[t.LineNumber] = i
+	}
+	var conflicts []Conflict
+	var removed []int
+	newBase := make([]baseLine, 0, len(theirs))
+	for bi, b := range base {
+		oi, inOurs := ours[b.LineNumber]
+		ti, inTheirs := theirsOf[bi]
+		var o, t string
+		if inOurs {
+			o = tf.Tasks[oi].original
+		}
+		if inTheirs {
+			t = theirText[ti]
+			newBase = append(newBase, baseLine{b.LineNumber, t})
+		}
+		switch {
+		case o == t, t == b.Text:
+			// unchanged by them, or changed the same way by both
+		case o == b.Text:
+			if inTheirs {
+				task := theirs[ti]
+				task.LineNumber = b.LineNumber
+				tf.Tasks[oi] = task
+			} else {
+				removed = append(removed, b.LineNumber)
+			}
+		default:
+			conflicts = append(conflicts, Conflict{
+				LineNumber: b.LineNumber,
+				Base:       b.Text,
+				Ours:       o,
+				Theirs:     t,
+			})
+		}
+	}
+
+	if len(removed) > 0 {
+		gone := make(map[int]bool)
+		for _, n := range removed {
+			gone[n] = true
+		}
+		var kept Tasks
+		for _, t := range tf.Tasks {
+			if !gone[t.LineNumber] {
+				kept = append(kept, t)
+			}
+		}
+		tf.Tasks = kept
+	}
+
+	inBase := make(map[int]bool)
+	for _, b := range base {
+		inBase[b.LineNumber] = true
+	}
+	existing := make(map[string]int)
+	for _, t := range tf.Tasks {
+		if !inBase[t.LineNumber] {
+			existing[t.original] = t.LineNumber
+		}
+	}
+	tf.base = newBase
+	for _, ti := range added {
+		task := theirs[ti]
+		if n, ok := existing[task.original]; ok {
+			// added on both sides
+			tf.base = append(tf.base, baseLine{n, task.original})
+			delete(existing, task.original)
+			continue
+		}
+		task.LineNumber = tf.nextLineNumber()
+		tf.Tasks = append(tf.Tasks, task)
+		tf.base = append(tf.base, baseLine{task.LineNumber, task.original})
+	}
+	return conflicts
+}
+
+type diffOp struct {
+	a, b int
+}
+
+// diff returns the longest common subsequence edit script turning a into
+// b. Each op has a and b set for a common line, only a for a deletion and
+// only b (a == -1) for an insertion.
+func diff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{i, j})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{i, -1})
+			i++
+		default:
+			ops = append(ops, diffOp{-1, j})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{i, -1})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{-1, j})
+	}
+	return ops
+}
@@ -24,9 +24,7 @@ package tdt
 
 import (
 	"errors"
-	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -35,101 +33,38 @@ var (
 	DoneRegex       = regexp.MustCompile(`^x\s+\d{4}-\d\d-\d\d\s+`)
 	PriorityRegex   = regexp.MustCompile(`^\(([A-Z])\)\s+`)
 	CreatedRegex    = regexp.MustCompile(`^(\d{4}-\d\d-\d\d)\s+`)
-	ThresholdRegex  = regexp.MustCompile(`\s+t:(\d{4}-\d\d-\d\d?|[\+*[0-9]*[a-z]+?)\b`)
-	DueRegex        = regexp.MustCompile(`\s+due:(\d{4}-\d\d-\d\d?|[\+*[0-9]*[a-z]+?)\b`)
-	RecurrenceRegex = regexp.MustCompile(`\s+rec:(\+*)(\d*)([dwmy]+?)\b`)
+	ThresholdRegex  = regexp.MustCompile(`\s+t:(\S+)`)
+	DueRegex        = regexp.MustCompile(`\s+due:(\S+)`)
+	RecurrenceRegex = regexp.MustCompile(`\s+rec:(\+*)(\d*)([A-Za-z][\w,-]*)`)
 	ProjectsRegex   = regexp.MustCompile(`\s+\+(\w+)\b`)
 	ContextsRegex   = regexp.MustCompile(`\s+@(\w+)\b`)
+	IdRegex         = regexp.MustCompile(`\s+id:([\w-]+)(\s|$)`)
+	TagRegex        = regexp.MustCompile(`(^|\s)([A-Za-z][\w-]*):([^\s:/]\S*)`)
 )
 
-func YMD(t time.Time) string {
-	return t.Format("2006-01-02")
-}
-
-func parseYMD(date string) (time.Time, error) {
-	return time.Parse("2006-01-02", date)
+// reservedTags are the keys that have their own fields in Task, and so
+// are not included in Task.Tags.
+var reservedTags = map[string]bool{
+	"t":   true,
+	"due": true,
+	"rec": true,
+	"id":  true,
 }
 
-func parseDayOfWeek(d time.Weekday) string {
-	return fmt.Sprintf("%dd", int((7+d-time.Now().Weekday())%7))
-}
-
-func parseNewDate(day string, d time.Time) time.Time {
-	parsed, err := parseYMD(day)
-	if err == nil {
-		return parsed.Local()
-	}
-
-	day = strings.ToLower(day)
-	switch day {
-	case "today", "t", "tday", "tod":
-		return time.Now()
-	case "tomorrow", "tm", "tom":
-		day = "d"
-	case "monday", "mon":
-		day = parseDayOfWeek(time.Monday)
-	case "tuesday", "tue":
-		day = parseDayOfWeek(time.Tuesday)
-	case "wednesday", "wed":
-		day = parseDayOfWeek(time.Wednesday)
-	case "thursday", "thu":
-		day = parseDayOfWeek(time.Thursday)
-	case "friday", "fri":
-		day = parseDayOfWeek(time.Friday)
-	case "saturday", "sat":
-		day = parseDayOfWeek(time.Saturday)
-	case "sunday", "sun":
-		day = parseDayOfWeek(time.Sunday)
-	}
-
-	r := newRecurrence(day)
-	if r.Period != "" {
-		d = r.getNextDate(d)
-	}
-
-	return d.Local()
+// YMD formats t as a date in Location.
+func YMD(t time.Time) string {
+	return t.In(Location).Format("2006-01-02")
 }
 
-func newRecurrence(rec string) Recurrence {
-	found := RecurrenceRegex.FindStringSubmatch(" rec:" + rec + " ")
-	if len(found) != 4 {
-		Log(log.Error, "Invalid format", rec)
-		return Recurrence{}
-	}
-	every, err := strconv.ParseInt((found[2]), 10, 32)
-	if err != nil {
-		every = 1
-	}
-	r := Recurrence{
-		Period: strings.ToLower(string(found[3])),
-		Every:  every,
-		Strict: string(found[1]) == "+",
-		String: strings.TrimPrefix(strings.TrimSpace(found[0]), "rec:"),
-	}
-	return r
+// parseYMD parses a date as midnight in Location.
+func parseYMD(date string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", date, Location)
 }
 
-func (r Recurrence) getNextDate(d time.Time) time.Time {
-	// Log(log.Debug, r)
-	// Log(log.Debug, YMD(d))
-	if !r.Strict {
-		d = time.Now().Truncate(24 * time.Hour)
-	}
-	// Log(log.Debug, YMD(d))
-	switch r.Period {
-	case "d":
-		d = d.AddDate(0, 0, int(r.Every))
-	case "w":
-		d = d.AddDate(0, 0, int(r.Every*7))
-	case "m":
-		d = d.AddDate(0, int(r.Every), 0)
-	case "y":
-		d = d.AddDate(int(r.Every), 0, 0)
-	default:
-		Log(log.Error, "eep")
-	}
-	// Log(log.Debug, YMD(d))
-	return d
+// isYMD reports whether s is a date written as YYYY-MM-DD.
+func isYMD(s string) bool {
+	_, err := parseYMD(s)
+	return err == nil
 }
 
 func parseChanges(line string) (string, string, string, string) {
@@ -150,7 +85,7 @@ func parseChanges(line string) (string, string, string, string) {
 
 	found = ThresholdRegex.FindStringSubmatch(line)
 	// Logf(log.Infof, "%+v %d", found, len(found))
-	if len(found) == 2 {
+	if len(found) == 2 && validDate(found[1]) {
 		threshold = strings.TrimSpace(string(found[1]))
 	} else {
 		threshold = ""
@@ -158,7 +93,7 @@ func parseChanges(line string) (string, string, string, string) {
 
 	found = DueRegex.FindStringSubmatch(line)
 	// Logf(log.Infof, "%+v %d", found, len(found))
-	if len(found) == 2 {
+	if len(found) == 2 && validDate(found[1]) && !dueOffsetRegex.MatchString(found[1]) {
 		due = strings.TrimSpace(string(found[1]))
 	} else {
 		due = ""
@@ -166,7 +101,8 @@ func parseChanges(line string) (string, string, string, string) {
 
 	found = RecurrenceRegex.FindStringSubmatch(line)
 	// Logf(log.Infof, "%+v %d", found, len(found))
-	if len(found) == 4 {
+	if len(found) == 4 && (strings.EqualFold(found[3], "x") ||
+		newRecurrence(found[1]+found[2]+found[3]).Period != "") {
 		recurrence = strings.TrimSpace(string(found[1]) + string(found[2]) + string(found[3]))
 	} else {
 		recurrence = ""
@@ -175,99 +111,158 @@ func parseChanges(line string) (string, string, string, string) {
 	return priority, threshold, due, recurrence
 }
 
+// parseTask parses a line of a task file. The line is kept as it is, in
+// Task.original, along with its tokens. Due and threshold dates must be
+// written as dates, or for a threshold relative to the due date; others,
+// such as due:fri, are left as they are rather than moving on each day.
 func parseTask(line string) (Task, error) {
-	parts := strings.Fields(line)
-	if len(parts) < 1 {
+	return parseLine(line, false)
+}
+
+// parseNewTask parses a line for a task being added or changed, in which
+// due and threshold dates can be relative to today, and writes those
+// dates out.
+func parseNewTask(line string) (Task, error) {
+	t, err := parseLine(line, true)
+	if err != nil {
+		return Task{}, err
+	}
+	t.fixDates()
+	return t, nil
+}
+
+// parseLine parses a task, reading due and threshold dates relative to
+// today only if relative is set.
+func parseLine(line string, relative bool) (Task, error) {
+	tokens := tokenize(line)
+	if len(tokens) < 1 {
 		return Task{}, errors.New("bad syntax")
 	}
 
 	task := Task{
 		original: line,
+		Priority: "z",
+		tokens:   tokens,
 	}
 
-	var err error
-	done := DoneRegex.MatchString(line)
-	if done {
-		task.Done = 1
-		task.Completed, err = parseYMD(parts[1])
-		if err != nil {
-			return Task{}, err
+	// The due date comes first, as the threshold can be relative to it
+	for i, tok := range tokens {
+		if tok.Kind != TokenTag || tok.Key != "due" || dueOffsetRegex.MatchString(tok.Value) {
+			continue
+		}
+		if !relative && !isYMD(tok.Value) {
+			continue
+		}
+		if d, ok := parseDate(tok.Value, Today()); ok {
+			tokens[i].Kind = TokenDue
+			task.Due = d
+			task.HasDue = true
+			break
 		}
-		// skip over the 'x' and completion date
-		parts = parts[2:]
-		line = strings.Join(parts, " ")
-	}
-
-	priority, threshold, due, recurrence := parseChanges(line)
-	// Log(log.Debug, priority, threshold, due, recurrence)
-
-	if priority != "" {
-		task.Priority = priority
-		parts = parts[1:]
-		line = strings.Join(parts, " ")
-	} else {
-		task.Priority = "z"
 	}
 
-	found := CreatedRegex.FindStringSubmatch(line)
-	// Logf(log.Infof, "%+v %d", found, len(found))
-	if len(found) == 2 {
-		task.Created, err = parseYMD((found[1]))
-		if err != nil {
-			return Task{}, err
+	var err error
+	var words []string
+	for i, tok := range tokens {
+		switch tok.Kind {
+		case TokenDone:
+			task.Done = 1
+		case TokenCompleted:
+			if task.Completed, err = parseYMD(tok.Text); err != nil {
+				return Task{}, err
+			}
+		case TokenPriority:
+			task.Priority = tok.Value
+		case TokenCreated:
+			if task.Created, err = parseYMD(tok.Text); err != nil {
+				return Task{}, err
+			}
+		case TokenProject:
+			task.Projects = append(task.Projects, tok.Value)
+			words = append(words, tok.Text)
+		case TokenContext:
+			task.Contexts = append(task.Contexts, tok.Value)
+			words = append(words, tok.Text)
+		case TokenTag:
+			if kind := task.parseTag(tok, relative); kind != TokenWord {
+				tokens[i].Kind = kind
+			} else {
+				words = append(words, tok.Text)
+			}
+		case TokenDue:
+		default:
+			words = append(words, tok.Text)
 		}
-		parts = parts[1:]
-		line = strings.Join(parts, " ")
 	}
-
-	if threshold != "" {
-		task.Threshold = parseNewDate(threshold, time.Now())
-		task.HasThreshold = true
-		line = strings.ReplaceAll(line, " t:"+threshold, "")
-		task.original = strings.ReplaceAll(task.original,
-			" t:"+threshold,
-			" t:"+YMD(task.Threshold))
-		parts = strings.Fields(line)
-		// } else {
-		// 	task.Threshold, _ = parseYMD("9999-12-31")
+	if task.Recurrence.Period != "" {
+		task.Recurrence.setEnd(task.Tags)
 	}
+	task.setDueStatus(Today())
 
-	if due != "" {
-		task.Due = parseNewDate(due, time.Now())
-		task.HasDue = true
-		if task.Due.Before(time.Now()) {
-			task.Overdue = true
-		}
-		line = strings.ReplaceAll(line, " due:"+due, "")
-		task.original = strings.ReplaceAll(task.original,
-			" due:"+due,
-			" due:"+YMD(task.Due))
-		parts = strings.Fields(line)
-		// } else {
-		// 	task.Due, _ = parseYMD("9999-12-31")
-	}
+	task.Description = strings.Join(words, " ")
 
-	if recurrence != "" {
-		task.Recurrence = newRecurrence(recurrence)
-		line = strings.ReplaceAll(line, " rec:"+recurrence, "")
-		parts = strings.Fields(line)
-	}
+	return task, nil
+}
 
-	matches := ProjectsRegex.FindAllStringSubmatch(line, -1)
-	for _, found := range matches {
-		if len(found) == 2 {
-			task.Projects = append(task.Projects, found[1])
-		}
+// rawTask returns a task for a line that parseTask can't read. Its tokens
+// are all taken as words, so it is kept, and written back, as it was.
+func rawTask(line string) Task {
+	tokens := tokenize(line)
+	words := make([]string, len(tokens))
+	for i := range tokens {
+		tokens[i].Kind = TokenWord
+		words[i] = tokens[i].Text
+	}
+	return Task{
+		original:    line,
+		Priority:    "z",
+		tokens:      tokens,
+		Description: strings.Join(words, " "),
 	}
+}
 
-	matches = ContextsRegex.FindAllStringSubmatch(line, -1)
-	for _, found := range matches {
-		if len(found) == 2 {
-			task.Contexts = append(task.Contexts, found[1])
+// parseTag sets the field of the task that tok is the tag for, and
+// returns the kind of token it turns out to be. Tags with their own field
+// that are repeated, or can't be parsed, are left in the description, as
+// are thresholds relative to today unless relative is set.
+func (t *Task) parseTag(tok Token, relative bool) TokenKind {
+	// A completed task keeps its priority in a pri: tag; on other tasks
+	// it is just a tag
+	if tok.Key == "pri" && t.IsDone() && !t.hasPriority() && priValueRegex.MatchString(tok.Value) {
+		t.Priority = tok.Value
+		return TokenPriTag
+	}
+	switch tok.Key {
+	case "t":
+		if t.HasThreshold {
+			break
+		}
+		if !relative && !isYMD(tok.Value) && !dueOffsetRegex.MatchString(tok.Value) {
+			break
+		}
+		if d, ok := parseThreshold(tok.Value, Today(), t); ok {
+			t.Threshold = d
+			t.HasThreshold = true
+			return TokenThreshold
+		}
+	case "rec":
+		if t.Recurrence.Period != "" {
+			break
+		}
+		if r := newRecurrence(tok.Value); r.Period != "" {
+			t.Recurrence = r
+			return TokenRecurrence
+		}
+	case "id":
+		if t.ID == "" && idValueRegex.MatchString(tok.Value) {
+			t.ID = tok.Value
+			return TokenID
+		}
+	default:
+		if !reservedTags[strings.ToLower(tok.Key)] {
+			t.Tags = append(t.Tags, Tag{Key: tok.Key, Value: tok.Value})
+			return TokenTag
 		}
 	}
-
-	task.Description = strings.Join(strings.Fields(line), " ")
-
-	return task, nil
+	return TokenWord
 }
@@ -0,0 +1,180 @@
+package tdt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTask(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tests := []struct {
+		line string
+		want Task
+	}{
+		{"call mom", Task{Priority: "z", Description: "call mom"}},
+		{"(A) call mom +family @phone", Task{Priority: "A", Description: "call mom +family @phone",
+			Projects: []string{"family"}, Contexts: []string{"phone"}}},
+		{"2024-04-01 created", Task{Priority: "z", Created: date("2024-04-01"), Description: "created"}},
+		{"(B) 2024-04-01 both", Task{Priority: "B", Created: date("2024-04-01"), Description: "both"}},
+		{"x 2024-04-02 2024-04-01 finished", Task{Done: 1, Priority: "z", Completed: date("2024-04-02"),
+			Created: date("2024-04-01"), Description: "finished"}},
+		{"x 2024-04-02 (C) finished", Task{Done: 1, Priority: "C", Completed: date("2024-04-02"),
+			Description: "finished"}},
+		{"report due:2024-05-03 t:2024-04-30", Task{Priority: "z", Description: "report",
+			Due: date("2024-05-03"), HasDue: true, Threshold: date("2024-04-30"), HasThreshold: true}},
+		{"late due:2024-04-30", Task{Priority: "z", Description: "late",
+			Due: date("2024-04-30"), HasDue: true, Overdue: true}},
+		{"now due:2024-05-01", Task{Priority: "z", Description: "now",
+			Due: date("2024-05-01"), HasDue: true, DueToday: true}},
+		{"x 2024-05-01 old due:2024-04-30", Task{Done: 1, Priority: "z", Completed: date("2024-05-01"),
+			Description: "old", Due: date("2024-04-30"), HasDue: true}},
+		{"water due:2024-05-02 rec:+1w", Task{Priority: "z", Description: "water",
+			Due: date("2024-05-02"), HasDue: true,
+			Recurrence: Recurrence{Period: "w", Every: 1, Strict: true, String: "+1w"}}},
+		{"tagged id:ab3 est:2 url:http://example.com", Task{ID: "ab3", Priority: "z",
+			Description: "tagged",
+			Tags:        Tags{{Key: "est", Value: "2"}, {Key: "url", Value: "http://example.com"}}}},
+	}
+	for _, tt := range tests {
+		got, err := parseTask(tt.line)
+		if err != nil {
+			t.Errorf("parseTask(%q): %v", tt.line, err)
+			continue
+		}
+		got.original, got.tokens = "", nil
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTask(%q) =\n%+v\nwant\n%+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	lines := []string{
+		"call mom",
+		"(A) 2024-04-01 call mom +family @phone",
+		"x 2024-04-02 2024-04-01 finished +work",
+		"x 2024-04-02 (C) finished",
+		"report due:2024-05-03 t:2024-04-30 rec:+1w",
+		"tagged id:ab3 est:2 url:http://example.com",
+		"odd  spacing   kept",
+	}
+	for _, line := range lines {
+		task, err := parseTask(line)
+		if err != nil {
+			t.Errorf("parseTask(%q): %v", line, err)
+			continue
+		}
+		if task.original != line {
+			t.Errorf("parseTask(%q) wrote back %q", line, task.original)
+		}
+	}
+}
+
+func TestParseRelativeDates(t *testing.T) {
+	fixClock(t, "2024-05-01") // a Wednesday
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"a due:today", "a due:2024-05-01"},
+		{"a due:tomorrow t:today", "a due:2024-05-02 t:2024-05-01"},
+		{"a due:3d", "a due:2024-05-04"},
+		{"a due:2w", "a due:2024-05-15"},
+		{"a due:fri", "a due:2024-05-03"},
+		{"a due:mon", "a due:2024-05-06"},
+		{"a due:wed", "a due:2024-05-01"},
+		{"a due:1b", "a due:2024-05-02"},
+	}
+	for _, tt := range tests {
+		task, err := parseTask(tt.line)
+		if err != nil {
+			t.Errorf("parseTask(%q): %v", tt.line, err)
+			continue
+		}
+		if task.original != tt.line {
+			t.Errorf("parseTask(%q) changed the line to %q", tt.line, task.original)
+		}
+		// Read from a file, the dates would move on each day
+		if task.HasDue || task.HasThreshold {
+			t.Errorf("parseTask(%q) read a date relative to today", tt.line)
+		}
+		if task, _ = parseNewTask(tt.line); task.original != tt.want {
+			t.Errorf("parseNewTask(%q) = %q, want %q", tt.line, task.original, tt.want)
+		}
+	}
+}
+
+func TestReadTasks(t *testing.T) {
+	tasks, err := readTasks([]byte("one\n\n  \ntwo\nx 2024-01-01 three\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, task := range tasks {
+		got = append(got, task.original)
+	}
+	if want := []string{"one", "two", "x 2024-01-01 three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readTasks = %q, want %q", got, want)
+	}
+	for i, task := range tasks {
+		if task.LineNumber != i {
+			t.Errorf("task %q has line number %d, want %d", task.original, task.LineNumber, i)
+		}
+	}
+
+	// A line that can't be parsed is kept as it is
+	tasks, err = readTasks([]byte("fine\n2024-13-45 bad date\n"))
+	if err != nil {
+		t.Fatalf("readTasks with a bad date: %v", err)
+	}
+	if len(tasks) != 2 || tasks[1].original != "2024-13-45 bad date" || tasks[1].LineNumber != 1 {
+		t.Fatalf("readTasks with a bad date = %+v", tasks)
+	}
+	if got := tasks[1].Description; got != "2024-13-45 bad date" {
+		t.Errorf("description of a bad line = %q, want the line", got)
+	}
+}
+
+func TestReadBadLine(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "x 2024-02-30 bad date", "fine")
+	tf.Add("new")
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"x 2024-02-30 bad date", "fine", "2024-05-01 new"}
+	if got := fileLines(t, s, "todo.txt"); !equalLines(got, want) {
+		t.Errorf("file after Write: %q, want %q", got, want)
+	}
+	problems, err := LintFrom(s, "todo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Line != 0 || problems[0].Fixable {
+		t.Errorf("LintFrom = %+v, want the bad line", problems)
+	}
+}
+
+func TestReadRelativeDates(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "call mom due:fri t:today")
+	task := tf.Tasks[0]
+	if task.HasDue || task.HasThreshold {
+		t.Errorf("relative dates read from the file: due %v, threshold %v", task.Due, task.Threshold)
+	}
+	// Written back as they were, for validate --fix to settle
+	tf.Add("other")
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fileLines(t, s, "todo.txt")[0]; got != "call mom due:fri t:today" {
+		t.Errorf("line after Write = %q", got)
+	}
+	if problems := LintLine(task.original); len(problems) != 1 || !problems[0].Fixable {
+		t.Errorf("LintLine = %+v, want a relative date to fix", problems)
+	}
+	if got, want := originals(tf.Fix())[0], "call mom due:2024-05-03 t:2024-05-01"; got != want {
+		t.Errorf("after Fix: %q, want %q", got, want)
+	}
+}
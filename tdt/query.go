@@ -0,0 +1,384 @@
+package tdt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a parsed filter expression. Terms are combined with AND, OR,
+// NOT and parentheses; terms next to each other are ANDed, and a term can
+// be negated with a leading - or !. The terms are:
+//
+//	+project @context   tasks in a project or context
+//	done overdue        completed or overdue tasks
+//	future recurring    tasks with a future threshold, or a recurrence
+//	pri:<=B             priority by letter, so pri:<=B is A or B
+//	due:<1w t:>=today   dates compared with a date (see parseDate), also
+//	created:<-30d       created: and completed: (or done:)
+//	age:>30             days since the task was created
+//	in:done in:todo     tasks from the done, trash or tasks file
+//	est:>2 url:*        any other tag, compared numerically if possible
+//	due:none url:any    whether a date or tag is set at all
+//	word "some text"    text in the description, ignoring case
+//
+// Comparisons are one of <, <=, >, >=, = (the default) and !=.
+type Query struct {
+	root   queryNode
+	source string
+}
+
+type queryNode interface {
+	match(t *Task) bool
+}
+
+type andNode struct{ left, right queryNode }
+type orNode struct{ left, right queryNode }
+type notNode struct{ node queryNode }
+type matchFunc func(t *Task) bool
+
+func (n andNode) match(t *Task) bool   { return n.left.match(t) && n.right.match(t) }
+func (n orNode) match(t *Task) bool    { return n.left.match(t) || n.right.match(t) }
+func (n notNode) match(t *Task) bool   { return !n.node.match(t) }
+func (f matchFunc) match(t *Task) bool { return f(t) }
+
+// ParseQuery parses a filter expression. An empty expression gives a nil
+// Query, which matches every task.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, queryError("unexpected %q", p.tokens[p.pos].text)
+	}
+	return &Query{root: root, source: strings.TrimSpace(s)}, nil
+}
+
+// Match reports whether t matches the query. A nil Query matches anything.
+func (q *Query) Match(t Task) bool {
+	if q == nil {
+		return true
+	}
+	return q.root.match(&t)
+}
+
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	return q.source
+}
+
+func queryError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: filter: %s", ErrParse, fmt.Sprintf(format, args...))
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+func lexQuery(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, queryToken{text: string(c)})
+			i++
+		case (c == '-' || c == '!') && i+1 < len(s) && s[i+1] != ' ':
+			tokens = append(tokens, queryToken{text: "NOT"})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, queryError("unterminated quote")
+			}
+			tokens = append(tokens, queryToken{text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		default:
+			end := strings.IndexAny(s[i:], " \t\n()")
+			if end < 0 {
+				end = len(s) - i
+			}
+			tokens = append(tokens, queryToken{text: s[i : i+end]})
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *queryParser) isKeyword(tok queryToken, words ...string) bool {
+	if tok.quoted {
+		return false
+	}
+	for _, w := range words {
+		if tok.text == w {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || !p.isKeyword(tok, "OR", "|", "||") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || p.isKeyword(tok, ")", "OR", "|", "||") {
+			return left, nil
+		}
+		if p.isKeyword(tok, "AND", "&", "&&") {
+			p.pos++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, queryError("missing term at end")
+	}
+	if p.isKeyword(tok, "NOT") {
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	if p.isKeyword(tok, "(") {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, ok := p.peek(); !ok || !p.isKeyword(tok, ")") {
+			return nil, queryError("missing )")
+		}
+		p.pos++
+		return node, nil
+	}
+	if p.isKeyword(tok, ")", "AND", "&", "&&", "OR", "|", "||") {
+		return nil, queryError("unexpected %q", tok.text)
+	}
+	p.pos++
+	return parseTerm(tok)
+}
+
+func parseTerm(tok queryToken) (queryNode, error) {
+	text := tok.text
+	if tok.quoted {
+		return textMatcher(text), nil
+	}
+	switch {
+	case strings.HasPrefix(text, "+") && len(text) > 1:
+		return listMatcher(text[1:], func(t *Task) []string { return t.Projects }), nil
+	case strings.HasPrefix(text, "@") && len(text) > 1:
+		return listMatcher(text[1:], func(t *Task) []string { return t.Contexts }), nil
+	}
+	switch strings.ToLower(text) {
+	case "done":
+		return matchFunc(func(t *Task) bool { return t.IsDone() }), nil
+	case "overdue":
+		return matchFunc(func(t *Task) bool { return t.Overdue }), nil
+	case "future":
+		return matchFunc(func(t *Task) bool { return t.IsFuture() }), nil
+	case "recurring":
+		return matchFunc(func(t *Task) bool { return t.Recurrence.Period != "" }), nil
+	}
+	colon := strings.Index(text, ":")
+	if colon <= 0 {
+		return textMatcher(text), nil
+	}
+	return parseComparison(strings.ToLower(text[:colon]), text[colon+1:])
+}
+
+func textMatcher(text string) queryNode {
+	text = strings.ToLower(text)
+	return matchFunc(func(t *Task) bool {
+		return strings.Contains(strings.ToLower(t.Description), text)
+	})
+}
+
+func listMatcher(name string, list func(t *Task) []string) queryNode {
+	return matchFunc(func(t *Task) bool {
+		for _, item := range list(t) {
+			if strings.EqualFold(item, name) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// splitOp splits a comparison such as <=B into its operator and value.
+func splitOp(s string) (string, string) {
+	for _, op := range []string{"<=", ">=", "!=", "<", ">", "="} {
+		if strings.HasPrefix(s, op) {
+			return op, s[len(op):]
+		}
+	}
+	return "=", s
+}
+
+func compareWith(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "!=":
+		return c != 0
+	}
+	return c == 0
+}
+
+// presence handles the none and any (or *) values, which test whether a
+// field is set at all.
+func presence(value string, has func(t *Task) bool) (queryNode, bool) {
+	switch strings.ToLower(value) {
+	case "any", "*":
+		return matchFunc(has), true
+	case "none":
+		return notNode{matchFunc(has)}, true
+	}
+	return nil, false
+}
+
+func parseComparison(key, expr string) (queryNode, error) {
+	op, value := splitOp(expr)
+	if value == "" {
+		return nil, queryError("missing value for %s:", key)
+	}
+	switch key {
+	case "pri", "priority":
+		has := func(t *Task) bool { return t.hasPriority() }
+		if node, ok := presence(value, has); ok {
+			return node, nil
+		}
+		value = strings.ToUpper(value)
+		if len(value) != 1 || value[0] < 'A' || value[0] > 'Z' {
+			return nil, queryError("invalid priority %q", value)
+		}
+		return matchFunc(func(t *Task) bool {
+			return has(t) && compareWith(op, strings.Compare(t.Priority, value))
+		}), nil
+	case "due":
+		return dateComparison(op, value,
+			func(t *Task) (time.Time, bool) { return t.Due, t.HasDue })
+	case "t", "threshold":
+		return dateComparison(op, value,
+			func(t *Task) (time.Time, bool) { return t.Threshold, t.HasThreshold })
+	case "created":
+		return dateComparison(op, value,
+			func(t *Task) (time.Time, bool) { return t.Created, !t.Created.IsZero() })
+	case "completed", "done":
+		return dateComparison(op, value,
+			func(t *Task) (time.Time, bool) { return t.Completed, !t.Completed.IsZero() })
+	case "age":
+		has := func(t *Task) bool { return !t.Created.IsZero() }
+		if node, ok := presence(value, has); ok {
+			return node, nil
+		}
+		days, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "d"))
+		if err != nil {
+			return nil, queryError("invalid age %q", value)
+		}
+		return matchFunc(func(t *Task) bool {
+			return has(t) && compareWith(op, compareInts(t.Age(), days))
+		}), nil
+	case "source", "in":
+		value = strings.ToLower(value)
+		if value == "todo" {
+			value = ""
+		} else if err := CheckInclude([]string{value}); err != nil {
+			return nil, queryError("%v", err)
+		}
+		return matchFunc(func(t *Task) bool {
+			return compareWith(op, strings.Compare(t.Source, value))
+		}), nil
+	case "id":
+		return matchFunc(func(t *Task) bool {
+			return compareWith(op, strings.Compare(t.ID, value))
+		}), nil
+	}
+	has := func(t *Task) bool { _, ok := t.Tag(key); return ok }
+	if node, ok := presence(value, has); ok {
+		return node, nil
+	}
+	return matchFunc(func(t *Task) bool {
+		v, ok := t.Tag(key)
+		return ok && compareWith(op, compareTagValues(v, value))
+	}), nil
+}
+
+func dateComparison(op, value string, date func(t *Task) (time.Time, bool)) (queryNode, error) {
+	has := func(t *Task) bool { _, ok := date(t); return ok }
+	if node, ok := presence(value, has); ok {
+		return node, nil
+	}
+	d, ok := parseDate(value, Today())
+	if !ok {
+		return nil, queryError("invalid date %q", value)
+	}
+	target := YMD(d)
+	return matchFunc(func(t *Task) bool {
+		d, ok := date(t)
+		return ok && compareWith(op, strings.Compare(YMD(d), target))
+	}), nil
+}
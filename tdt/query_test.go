@@ -0,0 +1,119 @@
+package tdt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, _ := newTestFile(t,
+		"(A) call bob +Home @phone due:2024-04-30",
+		"(C) write report +work due:2024-05-03 est:3 id:rep",
+		"plan holiday +home t:2024-06-01",
+		"x 2024-04-29 email alice +work @computer",
+		"water plants due:2024-05-01 rec:+1w est:1",
+	)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a", "b", "c", "d", "e"}},
+		{"+home", []string{"a", "c"}},
+		{"+work @computer", []string{"d"}},
+		{"+work AND @computer", []string{"d"}},
+		{"+work OR @phone", []string{"a", "b", "d"}},
+		{"+work | @phone", []string{"a", "b", "d"}},
+		{"-+work", []string{"a", "c", "e"}},
+		{"NOT done", []string{"a", "b", "c", "e"}},
+		{"!(+work OR +home)", []string{"e"}},
+		{"overdue", []string{"a"}},
+		{"future", []string{"c"}},
+		{"recurring", []string{"e"}},
+		{"pri:A", []string{"a"}},
+		{"pri:<=C", []string{"a", "b"}},
+		{"pri:none", []string{"c", "d", "e"}},
+		{"due:<=today", []string{"a", "e"}},
+		{"due:>2024-05-01", []string{"b"}},
+		{"due:any", []string{"a", "b", "e"}},
+		{"due:none AND NOT done", []string{"c"}},
+		{"t:any", []string{"c"}},
+		{"id:rep", []string{"b"}},
+		{"est:>2", []string{"b"}},
+		{"est:any", []string{"b", "e"}},
+		{"report", []string{"b"}},
+		{"\"email alice\"", []string{"d"}},
+		{"WATER", []string{"e"}},
+		{"plan or report", nil},
+	}
+	names := map[string]string{
+		"call bob +Home @phone":       "a",
+		"write report +work":          "b",
+		"plan holiday +home":          "c",
+		"email alice +work @computer": "d",
+		"water plants":                "e",
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, task := range tf.Tasks {
+			if q.Match(task) {
+				got = append(got, names[task.Description])
+			}
+		}
+		if !equalLines(got, tt.want) {
+			t.Errorf("%q matched %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryDates(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, _ := newTestFile(t,
+		"2024-04-01 a",
+		"b",
+		"x 2024-04-29 2024-04-20 c",
+		"2024-04-28 d",
+	)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"created:<-7d", []string{"a", "c"}},
+		{"created:>=1-week-ago", []string{"d"}},
+		{"age:>20", []string{"a"}},
+		{"age:<=3d", []string{"d"}},
+		{"age:none", []string{"b"}},
+		{"completed:>=-7d", []string{"c"}},
+		{"done:=2-days-ago", []string{"c"}},
+		{"completed:none", []string{"a", "b", "d"}},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, task := range tf.Tasks {
+			if q.Match(task) {
+				got = append(got, task.Description)
+			}
+		}
+		if !equalLines(got, tt.want) {
+			t.Errorf("%q matched %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, s := range []string{"(+work", "+work)", "+work OR", "due:<=soon", "pri:AB", "age:old", "\"open"} {
+		if _, err := ParseQuery(s); !errors.Is(err, ErrParse) {
+			t.Errorf("ParseQuery(%q) = %v, want a parse error", s, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package tdt
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	nthWeekdayRegex = regexp.MustCompile(`^(1st|2nd|3rd|4th|5th|last)-([a-z]+)$`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// newRecurrence parses the value of a rec: tag. The forms are:
+//
+//	3d 2w 1m 1y   every so many days, weeks, months or years
+//	5b            every five business days (Monday to Friday)
+//	mon,wed,fri   on each of the given days of the week
+//	2nd-tue       on the second Tuesday of each month (1st to 5th, or last)
+//	eom           on the last day of each month (3eom for every third)
+//
+// A leading + makes the recurrence strict: the next date is counted from
+// the previous one rather than from the day the task is completed.
+func newRecurrence(rec string) Recurrence {
+	found := RecurrenceRegex.FindStringSubmatch(" rec:" + rec + " ")
+	if len(found) != 4 {
+		Log(log.Error, "Invalid format", rec)
+		return Recurrence{}
+	}
+	r := Recurrence{
+		Every:  1,
+		Strict: found[1] != "",
+		String: strings.TrimPrefix(strings.TrimSpace(found[0]), "rec:"),
+	}
+	spec := strings.ToLower(found[3])
+	if found[2] != "" {
+		every, err := strconv.ParseInt(found[2], 10, 32)
+		if err != nil || every < 1 {
+			Log(log.Error, "Invalid format", rec)
+			return Recurrence{}
+		}
+		r.Every = every
+	}
+	switch spec {
+	case "d", "w", "m", "y", "b", "eom":
+		r.Period = spec
+		return r
+	}
+	if match := nthWeekdayRegex.FindStringSubmatch(found[2] + spec); match != nil {
+		day, ok := weekdays[match[2]]
+		if ok {
+			r.Period = "nth"
+			r.Every = 1
+			r.Nth = nthNumber(match[1])
+			r.Weekdays = []time.Weekday{day}
+			return r
+		}
+	} else if found[2] == "" {
+		r.Period = "weekday"
+		for _, name := range strings.Split(spec, ",") {
+			day, ok := weekdays[name]
+			if !ok {
+				r.Period = ""
+				break
+			}
+			r.Weekdays = append(r.Weekdays, day)
+		}
+		if r.Period != "" {
+			return r
+		}
+	}
+	Log(log.Error, "Invalid format", rec)
+	return Recurrence{}
+}
+
+func nthNumber(nth string) int {
+	if nth == "last" {
+		return -1
+	}
+	return int(nth[0] - '0')
+}
+
+// getNextDate returns the date after d on which the task recurs. Unless
+// the recurrence is strict, d is replaced by today.
+func (r Recurrence) getNextDate(d time.Time) time.Time {
+	if !r.Strict {
+		d = Today()
+	}
+	return r.nextDate(d)
+}
+
+func (r Recurrence) nextDate(d time.Time) time.Time {
+	every := int(r.Every)
+	if every < 1 {
+		every = 1
+	}
+	switch r.Period {
+	case "d":
+		return d.AddDate(0, 0, every)
+	case "w":
+		return d.AddDate(0, 0, every*7)
+	case "m":
+		return d.AddDate(0, every, 0)
+	case "y":
+		return d.AddDate(every, 0, 0)
+	case "b":
+		for n := 0; n < every; {
+			d = d.AddDate(0, 0, 1)
+			if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+				n++
+			}
+		}
+		return d
+	case "weekday":
+		for i := 1; i <= 7; i++ {
+			next := d.AddDate(0, 0, i)
+			for _, day := range r.Weekdays {
+				if next.Weekday() == day {
+					return next
+				}
+			}
+		}
+	case "eom":
+		next := lastOfMonth(d, 0)
+		if !next.After(d) {
+			next = lastOfMonth(d, every)
+		}
+		return next
+	case "nth":
+		for months := 0; months <= 12*every; months += every {
+			next, ok := nthWeekday(d, months, r.Nth, r.Weekdays[0])
+			if ok && next.After(d) {
+				return next
+			}
+		}
+	}
+	Log(log.Error, "eep")
+	return d
+}
+
+// lastOfMonth returns the last day of the month the given number of months
+// after d's.
+func lastOfMonth(d time.Time, months int) time.Time {
+	first := time.Date(d.Year(), d.Month(), 1, d.Hour(), d.Minute(), d.Second(),
+		d.Nanosecond(), d.Location())
+	return first.AddDate(0, months+1, -1)
+}
+
+// nthWeekday returns the nth given day of the week in the month the given
+// number of months after d's, or the last one if nth is -1. It reports
+// false if the month has no such day, such as a fifth Monday.
+func nthWeekday(d time.Time, months, nth int, day time.Weekday) (time.Time, bool) {
+	first := time.Date(d.Year(), d.Month(), 1, d.Hour(), d.Minute(), d.Second(),
+		d.Nanosecond(), d.Location()).AddDate(0, months, 0)
+	if nth < 0 {
+		last := first.AddDate(0, 1, -1)
+		return last.AddDate(0, 0, -int((7+last.Weekday()-day)%7)), true
+	}
+	next := first.AddDate(0, 0, int((7+day-first.Weekday())%7)+7*(nth-1))
+	return next, next.Month() == first.Month()
+}
+
+// setEnd reads the until: and count: tags that end a recurrence.
+func (r *Recurrence) setEnd(tags Tags) {
+	if until, ok := tags.Get("until"); ok {
+		if _, err := parseYMD(until); err == nil {
+			r.Until = until
+		}
+	}
+	if count, ok := tags.Get("count"); ok {
+		if n, err := strconv.Atoi(count); err == nil && n > 0 {
+			r.Count = n
+		}
+	}
+}
+
+// nextInstance returns the task that replaces t when it is completed, or
+// false if t does not recur or its recurrence has ended. The due date is
+// moved on by the recurrence, and the threshold date by the same number
+// of days, so that the gap between them is kept.
+func (t Task) nextInstance() (Task, bool) {
+	r := t.Recurrence
+	if r.Period == "" || r.Count == 1 {
+		return Task{}, false
+	}
+	n := t
+	switch {
+	case n.HasDue:
+		d := r.getNextDate(n.Due)
+		if n.HasThreshold {
+			n.setThreshold(n.Threshold.AddDate(0, 0, daysBetween(n.Due, d)))
+		}
+		n.setDue(d)
+	case n.HasThreshold:
+		n.setThreshold(r.getNextDate(n.Threshold))
+	default:
+		return Task{}, false
+	}
+	if r.Until != "" {
+		next := n.Threshold
+		if n.HasDue {
+			next = n.Due
+		}
+		if YMD(next) > r.Until {
+			return Task{}, false
+		}
+	}
+	if r.Count > 1 {
+		n.setTagValue("count", strconv.Itoa(r.Count-1))
+	}
+	return n, true
+}
+
+func (t *Task) setDue(d time.Time) {
+	t.setTag(TokenDue, "due", YMD(d))
+}
+
+func (t *Task) setThreshold(d time.Time) {
+	t.setTag(TokenThreshold, "t", YMD(d))
+}
@@ -0,0 +1,166 @@
+package tdt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	d, err := parseYMD(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestNewRecurrence(t *testing.T) {
+	tests := []struct {
+		rec  string
+		want Recurrence
+	}{
+		{"d", Recurrence{Period: "d", Every: 1, String: "d"}},
+		{"3d", Recurrence{Period: "d", Every: 3, String: "3d"}},
+		{"+2w", Recurrence{Period: "w", Every: 2, Strict: true, String: "+2w"}},
+		{"1m", Recurrence{Period: "m", Every: 1, String: "1m"}},
+		{"+1y", Recurrence{Period: "y", Every: 1, Strict: true, String: "+1y"}},
+		{"5b", Recurrence{Period: "b", Every: 5, String: "5b"}},
+		{"eom", Recurrence{Period: "eom", Every: 1, String: "eom"}},
+		{"+3eom", Recurrence{Period: "eom", Every: 3, Strict: true, String: "+3eom"}},
+		{"mon,wed,fri", Recurrence{Period: "weekday", Every: 1, String: "mon,wed,fri",
+			Weekdays: []time.Weekday{time.Monday, time.Wednesday, time.Friday}}},
+		{"Sunday", Recurrence{Period: "weekday", Every: 1, String: "Sunday",
+			Weekdays: []time.Weekday{time.Sunday}}},
+		{"2nd-tue", Recurrence{Period: "nth", Every: 1, Nth: 2, String: "2nd-tue",
+			Weekdays: []time.Weekday{time.Tuesday}}},
+		{"+last-fri", Recurrence{Period: "nth", Every: 1, Nth: -1, Strict: true, String: "+last-fri",
+			Weekdays: []time.Weekday{time.Friday}}},
+		{"", Recurrence{}},
+		{"0d", Recurrence{}},
+		{"2x", Recurrence{}},
+		{"mon,xyz", Recurrence{}},
+		{"3mon", Recurrence{}},
+		{"6th-mon", Recurrence{}},
+		{"2nd-xyz", Recurrence{}},
+	}
+	for _, tt := range tests {
+		if got := newRecurrence(tt.rec); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("newRecurrence(%q) = %+v, want %+v", tt.rec, got, tt.want)
+		}
+	}
+}
+
+func TestNextDate(t *testing.T) {
+	tests := []struct {
+		rec  string
+		from string
+		want string
+	}{
+		{"+1d", "2024-01-31", "2024-02-01"},
+		{"+10d", "2024-02-25", "2024-03-06"},
+		{"+1w", "2024-12-28", "2025-01-04"},
+		{"+1m", "2024-01-15", "2024-02-15"},
+		{"+1m", "2024-01-31", "2024-03-02"},
+		{"+1y", "2023-06-01", "2024-06-01"},
+		// 2024-05-03 is a Friday
+		{"+1b", "2024-05-03", "2024-05-06"},
+		{"+1b", "2024-05-04", "2024-05-06"},
+		{"+5b", "2024-05-01", "2024-05-08"},
+		{"+10b", "2024-05-03", "2024-05-17"},
+		{"+mon,wed,fri", "2024-05-01", "2024-05-03"},
+		{"+mon,wed,fri", "2024-05-03", "2024-05-06"},
+		{"+wed", "2024-05-01", "2024-05-08"},
+		{"+sun", "2024-05-04", "2024-05-05"},
+		{"+eom", "2024-01-15", "2024-01-31"},
+		{"+eom", "2024-01-31", "2024-02-29"},
+		{"+eom", "2023-02-28", "2023-03-31"},
+		{"+2eom", "2024-11-30", "2025-01-31"},
+		{"+eom", "2024-12-31", "2025-01-31"},
+		{"+1st-mon", "2024-05-01", "2024-05-06"},
+		{"+1st-mon", "2024-05-06", "2024-06-03"},
+		{"+2nd-tue", "2024-05-01", "2024-05-14"},
+		{"+2nd-tue", "2024-05-14", "2024-06-11"},
+		{"+2nd-tue", "2024-12-20", "2025-01-14"},
+		{"+last-fri", "2024-05-01", "2024-05-31"},
+		{"+last-fri", "2024-05-31", "2024-06-28"},
+		{"+last-sun", "2024-02-01", "2024-02-25"},
+		// There are five Wednesdays in May 2024, but none in June
+		{"+5th-wed", "2024-05-01", "2024-05-29"},
+		{"+5th-wed", "2024-05-29", "2024-07-31"},
+		{"+5th-fri", "2024-02-10", "2024-03-29"},
+	}
+	for _, tt := range tests {
+		r := newRecurrence(tt.rec)
+		if r.Period == "" {
+			t.Errorf("newRecurrence(%q) failed", tt.rec)
+			continue
+		}
+		if got := YMD(r.getNextDate(date(tt.from))); got != tt.want {
+			t.Errorf("rec:%s from %s = %s, want %s", tt.rec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestNextDateFromToday(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	r := newRecurrence("3d")
+	if got, want := YMD(r.getNextDate(date("2000-01-01"))), "2024-05-04"; got != want {
+		t.Errorf("rec:3d = %s, want %s", got, want)
+	}
+}
+
+func TestNextInstance(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"(A) 2024-01-01 pay rent due:2024-05-01 rec:+1m",
+			"(A) 2024-05-01 pay rent due:2024-06-01 rec:+1m"},
+		{"2024-01-01 report t:2024-04-29 due:2024-05-03 rec:+1w",
+			"2024-05-01 report t:2024-05-06 due:2024-05-10 rec:+1w"},
+		{"standup t:2024-05-03 rec:+1b",
+			"2024-05-01 standup t:2024-05-06 rec:+1b"},
+		{"(B) invoice due:2024-05-31 rec:+eom count:3",
+			"(B) 2024-05-01 invoice due:2024-06-30 rec:+eom count:2"},
+		{"invoice due:2024-05-31 rec:+eom count:1", ""},
+		{"club due:2024-05-14 rec:+2nd-tue until:2024-06-30",
+			"2024-05-01 club due:2024-06-11 rec:+2nd-tue until:2024-06-30"},
+		{"club due:2024-06-11 rec:+2nd-tue until:2024-06-30", ""},
+		{"no dates rec:+1w", ""},
+		{"not recurring due:2024-05-01", ""},
+	}
+	for _, tt := range tests {
+		task, err := parseTask(tt.line)
+		if err != nil {
+			t.Fatalf("parseTask(%q): %v", tt.line, err)
+		}
+		n, ok := task.nextInstance()
+		got := ""
+		if ok {
+			n.setCreated(date("2024-05-01"))
+			got = n.original
+		}
+		if got != tt.want {
+			t.Errorf("next instance of %q = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestToggleRecurring(t *testing.T) {
+	tf := &TaskFile{}
+	tf.Add("water plants due:2024-05-01 rec:+1w count:2")
+	tf.Toggle(false, 0)
+	if len(tf.Tasks) != 2 {
+		t.Fatalf("got %d tasks after completing, want 2", len(tf.Tasks))
+	}
+	next := tf.Tasks[1]
+	if !strings.Contains(next.original, "due:2024-05-08") ||
+		!strings.Contains(next.original, "count:1") || next.IsDone() {
+		t.Errorf("next instance is %q", next.original)
+	}
+	tf.Toggle(false, next.LineNumber)
+	if len(tf.Tasks) != 2 {
+		t.Errorf("got %d tasks after completing the last instance, want 2", len(tf.Tasks))
+	}
+}
@@ -0,0 +1,221 @@
+package tdt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sortKey is a named order for tasks. has reports whether a task has the
+// field being sorted on; cmp orders two tasks that both have it, in the
+// key's default (+) direction.
+type sortKey struct {
+	names []string
+	has   func(t *Task) bool
+	cmp   func(a, b *Task) int
+}
+
+// sortKeys are the keys a sort order can use, besides tag:KEY. The first
+// name of each is the one shown in errors.
+var sortKeys = []sortKey{
+	{[]string{"done", "x"}, always,
+		func(a, b *Task) int { return compareInts(a.Done, b.Done) }},
+	{[]string{"priority", "pri", "p"}, (*Task).hasPriority,
+		func(a, b *Task) int { return strings.Compare(a.Priority, b.Priority) }},
+	{[]string{"due", "d"}, func(t *Task) bool { return t.HasDue },
+		func(a, b *Task) int { return compareTimes(b.Due, a.Due) }},
+	{[]string{"threshold", "t"}, func(t *Task) bool { return t.HasThreshold },
+		func(a, b *Task) int { return compareTimes(b.Threshold, a.Threshold) }},
+	{[]string{"created"}, func(t *Task) bool { return !t.Created.IsZero() },
+		func(a, b *Task) int { return compareTimes(b.Created, a.Created) }},
+	{[]string{"completed"}, func(t *Task) bool { return !t.Completed.IsZero() },
+		func(a, b *Task) int { return compareTimes(b.Completed, a.Completed) }},
+	{[]string{"age"}, func(t *Task) bool { return !t.Created.IsZero() },
+		func(a, b *Task) int { return compareTimes(a.Created, b.Created) }},
+	{[]string{"description", "text"}, always,
+		func(a, b *Task) int { return compareNatural(a.Description, b.Description) }},
+	{[]string{"project"}, func(t *Task) bool { return len(t.Projects) > 0 },
+		func(a, b *Task) int { return compareNatural(a.Projects[0], b.Projects[0]) }},
+	{[]string{"context"}, func(t *Task) bool { return len(t.Contexts) > 0 },
+		func(a, b *Task) int { return compareNatural(a.Contexts[0], b.Contexts[0]) }},
+}
+
+func always(t *Task) bool { return true }
+
+// sortField is one key of a sort order. Tasks without the field come last
+// unless nullsFirst is set, whichever the direction.
+type sortField struct {
+	key        sortKey
+	desc       bool
+	nullsFirst bool
+}
+
+// sortOrder is a parsed Opts.SortOrder.
+type sortOrder []sortField
+
+// parseSortOrder parses a sort order: keys separated by commas or spaces,
+// each optionally followed by + or - for its direction, and then by
+// :first or :last for where tasks without the field go. Unknown keys are
+// left out of the order, and named in the error.
+func parseSortOrder(s string) (sortOrder, error) {
+	var order sortOrder
+	var unknown []string
+	for _, f := range strings.Fields(strings.ReplaceAll(strings.ToLower(s), ",", " ")) {
+		if field, ok := parseSortField(f); ok {
+			order = append(order, field)
+		} else {
+			unknown = append(unknown, fmt.Sprintf("%q", f))
+		}
+	}
+	if len(unknown) > 0 {
+		var names []string
+		for _, k := range sortKeys {
+			names = append(names, k.names[0])
+		}
+		return order, fmt.Errorf("%w: sort: unknown key %s (use %s or tag:KEY, each with + or - and :first or :last after it)",
+			ErrParse, strings.Join(unknown, ", "), strings.Join(names, ", "))
+	}
+	return order, nil
+}
+
+func parseSortField(f string) (sortField, bool) {
+	var field sortField
+	for _, nulls := range []string{":first", ":last"} {
+		// tag:first is the tag named first
+		if rest := strings.TrimSuffix(f, nulls); rest != f && rest != "tag" {
+			f, field.nullsFirst = rest, nulls == ":first"
+			break
+		}
+	}
+	if strings.HasSuffix(f, "-") {
+		f, field.desc = strings.TrimSuffix(f, "-"), true
+	} else {
+		f = strings.TrimSuffix(f, "+")
+	}
+	if strings.HasPrefix(f, "tag:") && len(f) > len("tag:") {
+		key := strings.TrimPrefix(f, "tag:")
+		field.key = sortKey{
+			names: []string{f},
+			has:   func(t *Task) bool { _, ok := t.Tag(key); return ok },
+			cmp: func(a, b *Task) int {
+				x, _ := a.Tag(key)
+				y, _ := b.Tag(key)
+				return compareTagValues(x, y)
+			},
+		}
+		return field, true
+	}
+	for _, k := range sortKeys {
+		for _, name := range k.names {
+			if name == f {
+				field.key = k
+				return field, true
+			}
+		}
+	}
+	return sortField{}, false
+}
+
+// CheckSortOrder returns an error unless order is a valid Opts.SortOrder.
+func CheckSortOrder(order string) error {
+	_, err := parseSortOrder(order)
+	return err
+}
+
+// less orders tasks by each field in turn, and then by file and line
+// number, so that any two different tasks are ordered the same way every
+// time.
+func (o sortOrder) less(a, b *Task) bool {
+	for _, f := range o {
+		hasA, hasB := f.key.has(a), f.key.has(b)
+		if hasA != hasB {
+			return hasA != f.nullsFirst
+		}
+		if !hasA {
+			continue
+		}
+		c := f.key.cmp(a, b)
+		if f.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	if a.Source != b.Source {
+		return a.Source < b.Source
+	}
+	return a.LineNumber < b.LineNumber
+}
+
+// sort sorts the tasks. Unknown keys in sortOrder are logged and left out,
+// and the tasks are sorted by the rest.
+func (tf *TaskFile) sort(sortOrder string) *TaskFile {
+	order, err := parseSortOrder(sortOrder)
+	if err != nil {
+		Log(log.Warning, err.Error())
+	}
+	sort.SliceStable(tf.Tasks, func(i, j int) bool {
+		return order.less(&tf.Tasks[i], &tf.Tasks[j])
+	})
+	return tf
+}
+
+func compareTimes(x, y time.Time) int {
+	switch {
+	case x.Before(y):
+		return -1
+	case x.After(y):
+		return 1
+	}
+	return 0
+}
+
+// compareNatural compares strings ignoring case, with runs of digits
+// compared as numbers, so that "step 9" comes before "step 10".
+func compareNatural(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			i, j := digitsEnd(a), digitsEnd(b)
+			x, y := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+			if len(x) != len(y) {
+				return compareInts(len(x), len(y))
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return compareInts(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitsEnd returns the length of the run of digits at the start of s.
+func digitsEnd(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+func compareInts(x, y int) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+package tdt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+// randomTasks returns tasks with a mix of fields set and not set, and
+// values that compare equal, so that every sort key has ties and tasks
+// without the field.
+func randomTasks(r *rand.Rand, n int) []Task {
+	pick := func(items ...string) string { return items[r.Intn(len(items))] }
+	dates := []string{"", "2024-04-01", "2024-04-15", "2024-05-01"}
+	var tasks []Task
+	for i := 0; i < n; i++ {
+		var words []string
+		if d := pick(dates...); d != "" && r.Intn(2) == 0 {
+			words = append(words, "x", d)
+		}
+		if p := pick("", "(A)", "(B)", "(C)"); p != "" && len(words) == 0 {
+			words = append(words, p)
+		}
+		if d := pick(dates...); d != "" {
+			words = append(words, d)
+		}
+		words = append(words, pick("step", "Step", "plan", "a"), pick("9", "10", "09", "x2"))
+		for _, w := range []string{
+			pick("", "+alpha", "+beta", "+Beta"),
+			pick("", "@home", "@desk"),
+			pick("", "est:1", "est:10", "est:9", "est:x", "est:1a", "est:2.5", "est:NaN"),
+		} {
+			if w != "" {
+				words = append(words, w)
+			}
+		}
+		if d := pick(dates...); d != "" {
+			words = append(words, "due:"+d)
+		}
+		if d := pick(dates...); d != "" {
+			words = append(words, "t:"+d)
+		}
+		task, err := parseTask(strings.Join(words, " "))
+		if err != nil {
+			panic(err)
+		}
+		task.LineNumber = i
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// sortOrders returns an order for each key, direction and placement of
+// tasks without the field, and a few of several keys.
+func sortOrders() []string {
+	orders := []string{"", "done,priority,due-,threshold-", "x p- tag:est:first", "project context- age"}
+	for _, k := range append(sortKeys, sortKey{names: []string{"tag:est"}}) {
+		for _, dir := range []string{"", "+", "-"} {
+			for _, nulls := range []string{"", ":first", ":last"} {
+				orders = append(orders, k.names[0]+dir+nulls)
+			}
+		}
+	}
+	return orders
+}
+
+func TestSortIsStrictOrder(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	r := rand.New(rand.NewSource(1))
+	tasks := randomTasks(r, 30)
+	for _, s := range sortOrders() {
+		order, err := parseSortOrder(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range tasks {
+			a := &tasks[i]
+			if order.less(a, a) {
+				t.Errorf("%q: %q is less than itself", s, a.original)
+			}
+			for j := range tasks {
+				b := &tasks[j]
+				if i != j && order.less(a, b) == order.less(b, a) {
+					t.Errorf("%q: %q and %q are not ordered one way", s, a.original, b.original)
+				}
+				for k := range tasks {
+					c := &tasks[k]
+					if order.less(a, b) && order.less(b, c) && !order.less(a, c) {
+						t.Errorf("%q: not transitive for %q, %q, %q", s, a.original, b.original, c.original)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSortIgnoresInputOrder(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	r := rand.New(rand.NewSource(2))
+	tasks := randomTasks(r, 40)
+	for _, s := range sortOrders() {
+		var want string
+		for n := 0; n < 5; n++ {
+			tf := &TaskFile{Tasks: append(Tasks(nil), tasks...)}
+			r.Shuffle(len(tf.Tasks), func(i, j int) { tf.Tasks[i], tf.Tasks[j] = tf.Tasks[j], tf.Tasks[i] })
+			got := fmt.Sprint(originals(tf.sort(s)))
+			if n == 0 {
+				want = got
+			} else if got != want {
+				t.Errorf("%q: sorting shuffled tasks gave\n%s\nthen\n%s", s, want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestSortNulls(t *testing.T) {
+	tf, _ := newTestFile(t, "a due:2024-05-02", "b", "c due:2024-05-01")
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"due-", []string{"c", "a", "b"}},
+		{"due-:first", []string{"b", "c", "a"}},
+		{"due:first", []string{"b", "a", "c"}},
+		{"due+:last", []string{"a", "c", "b"}},
+	}
+	for _, tt := range tests {
+		if got := descriptions(tf.sort(tt.order)); !equalLines(got, tt.want) {
+			t.Errorf("sort %q = %q, want %q", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestSortUnknownKey(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.MustGetLogger("test")
+	l.SetBackend(logging.AddModuleLevel(logging.NewLogBackend(&buf, "", 0)))
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	tf, _ := newTestFile(t, "a due:2024-05-02", "b", "c due:2024-05-01")
+	want := descriptions(tf.sort("due-"))
+	if got := descriptions(tf.sort("dew,due-")); !equalLines(got, want) {
+		t.Errorf("sort by a bad key and due- = %q, want %q", got, want)
+	}
+	if !strings.Contains(buf.String(), `"dew"`) {
+		t.Errorf("unknown sort key not logged: %q", buf.String())
+	}
+}
+
+func TestCheckSortOrder(t *testing.T) {
+	for _, s := range []string{"", "done,priority,due-,threshold-", "D+ t- x", "tag:est-:first", "tag:first", "text age-:last"} {
+		if err := CheckSortOrder(s); err != nil {
+			t.Errorf("CheckSortOrder(%q) = %v", s, err)
+		}
+	}
+	for _, s := range []string{"dew", "due,prio", "tag:", "due:none", "due*"} {
+		if err := CheckSortOrder(s); !errors.Is(err, ErrParse) {
+			t.Errorf("CheckSortOrder(%q) = %v, want a parse error", s, err)
+		}
+	}
+	err := CheckSortOrder("due,prio")
+	if err == nil || !strings.Contains(err.Error(), `"prio"`) || !strings.Contains(err.Error(), "priority") {
+		t.Errorf("error %v should name the bad key and the good ones", err)
+	}
+}
+
+func TestCompareNatural(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"step 9", "step 10", -1},
+		{"Step 10", "step 9", 1},
+		{"step 09", "step 9", 0},
+		{"apple", "Apple", 0},
+		{"a", "ab", -1},
+		{"x2y", "x2z", -1},
+	}
+	for _, tt := range tests {
+		if got := compareNatural(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareNatural(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestCompareConsistent checks the comparisons used by sort keys are
+// consistent: antisymmetric and transitive, for values of mixed kinds.
+func TestCompareConsistent(t *testing.T) {
+	values := []string{"", "1", "01", "1.0", "10", "9", "9a", "1a", "x", "X", "-2", "NaN", "inf", "a10", "a9", "a09b", "/", "~"}
+	for name, cmp := range map[string]func(a, b string) int{
+		"compareTagValues": compareTagValues,
+		"compareNatural":   compareNatural,
+	} {
+		for _, a := range values {
+			for _, b := range values {
+				if cmp(a, b) != -cmp(b, a) {
+					t.Errorf("%s(%q, %q) = %d but reversed is %d", name, a, b, cmp(a, b), cmp(b, a))
+				}
+				for _, c := range values {
+					if cmp(a, b) <= 0 && cmp(b, c) <= 0 && cmp(a, c) > 0 {
+						t.Errorf("%s: %q <= %q <= %q but %q > %q", name, a, b, c, a, c)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package tdt
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readCorpus returns the lines of a file in testdata, without comments
+// and blank lines.
+func readCorpus(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func setStrict(t *testing.T, strict bool) {
+	old := Strict
+	Strict = strict
+	t.Cleanup(func() { Strict = old })
+}
+
+// taskFields returns the fields of a task compared by the spec corpus.
+func taskFields(task Task) map[string]string {
+	f := map[string]string{
+		"projects": strings.Join(task.Projects, ","),
+		"contexts": strings.Join(task.Contexts, ","),
+		"desc":     task.Description,
+	}
+	if task.IsDone() {
+		f["done"] = "1"
+	}
+	if task.hasPriority() {
+		f["pri"] = task.Priority
+	}
+	if !task.Completed.IsZero() {
+		f["completed"] = YMD(task.Completed)
+	}
+	if !task.Created.IsZero() {
+		f["created"] = YMD(task.Created)
+	}
+	if task.HasDue {
+		f["due"] = YMD(task.Due)
+	}
+	return f
+}
+
+func TestSpecCorpus(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		setStrict(t, strict)
+		mode, other := "loose.", "strict."
+		if strict {
+			mode, other = other, mode
+		}
+		for _, c := range readCorpus(t, "spec.txt") {
+			line, spec, _ := strings.Cut(c, " | ")
+			want := map[string]string{}
+			for _, field := range strings.Split(spec, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+				if strings.HasPrefix(key, other) {
+					continue
+				}
+				want[strings.TrimPrefix(key, mode)] = value
+			}
+			task, err := parseTask(line)
+			if err != nil {
+				t.Errorf("%s%q: %v", mode, line, err)
+				continue
+			}
+			got := taskFields(task)
+			for _, key := range []string{"done", "pri", "completed", "created", "projects", "contexts", "due", "desc"} {
+				if got[key] != want[key] {
+					t.Errorf("%s%q: %s = %q, want %q", mode, line, key, got[key], want[key])
+				}
+			}
+		}
+	}
+}
+
+func TestLintCorpus(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	for _, c := range readCorpus(t, "lint.txt") {
+		cols := strings.Split(c, "\t")
+		if len(cols) != 3 {
+			t.Fatalf("bad corpus line %q", c)
+		}
+		line, fixed := cols[0], cols[2]
+		var want []string
+		if cols[1] != "" {
+			want = strings.Split(cols[1], "; ")
+		}
+		var got []string
+		for _, p := range LintLine(line) {
+			got = append(got, p.Reason)
+		}
+		if !equalLines(got, want) {
+			t.Errorf("LintLine(%q) = %q, want %q", line, got, want)
+		}
+		if got := FixLine(line); got != fixed {
+			t.Errorf("FixLine(%q) = %q, want %q", line, got, fixed)
+		}
+		if again := FixLine(fixed); again != fixed {
+			t.Errorf("FixLine(%q) is not idempotent", fixed)
+		}
+	}
+}
+
+func TestLintFrom(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	s := NewMemoryStorage()
+	s.Write("todo.txt", []byte("(a) one\n\nx two\n2024-04-01 fine\n"))
+	problems, err := LintFrom(s, "todo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{"line 1: priority is a lowercase letter", "line 3: completed without a completion date"}
+	if !equalLines(got, want) {
+		t.Errorf("LintFrom = %q, want %q", got, want)
+	}
+}
+
+func TestFix(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "(a) one", "x two", "2024-04-01 fine")
+	if err := tf.Fix().Write(); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := LintFrom(s, "todo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Line != 1 || problems[0].Fixable {
+		t.Errorf("problems left after fixing: %+v", problems)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"(A) one", "x two", "2024-04-01 fine"}; !equalLines(got, want) {
+		t.Errorf("file after fixing: %q, want %q", got, want)
+	}
+	if _, err := tf.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"(a) one", "x two", "2024-04-01 fine"}; !equalLines(got, want) {
+		t.Errorf("file after undoing the fixes: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package tdt
+
+import (
+	"sort"
+	"time"
+)
+
+// Stats summarises a task file together with its done file, and its
+// trash file if Opts.Include names it. Only tasks matching the file's
+// query are counted.
+type Stats struct {
+	Open      int `json:"open"`
+	Completed int `json:"completed"`
+	// Archived is how many of the completed tasks are in the done file
+	Archived int `json:"archived"`
+	Overdue  int `json:"overdue"`
+	DueToday int `json:"due_today"`
+	// LeadTime is the average number of days from creation to completion,
+	// over the LeadTimeTasks completed tasks that have both dates.
+	LeadTime      float64 `json:"average_lead_time_days"`
+	LeadTimeTasks int     `json:"lead_time_tasks"`
+	// PerDay and PerWeek are the average numbers of tasks completed over
+	// the Days and Weeks.
+	PerDay   float64       `json:"completed_per_day"`
+	PerWeek  float64       `json:"completed_per_week"`
+	Days     []PeriodStats `json:"days"`
+	Weeks    []PeriodStats `json:"weeks"`
+	Projects []GroupStats  `json:"projects"`
+	Contexts []GroupStats  `json:"contexts"`
+}
+
+// PeriodStats counts the tasks created and completed in a day, or a week
+// starting on a Monday, and the tasks left open at the end of it.
+type PeriodStats struct {
+	Start     string `json:"start"`
+	Created   int    `json:"created"`
+	Completed int    `json:"completed"`
+	Open      int    `json:"open"`
+}
+
+// GroupStats counts the tasks in a project or context.
+type GroupStats struct {
+	Name      string `json:"name"`
+	Open      int    `json:"open"`
+	Completed int    `json:"completed"`
+	Overdue   int    `json:"overdue"`
+}
+
+// Stats returns the statistics for the file and its done file, with the
+// given number of days and weeks, up to today, in Days and Weeks.
+func (tf *TaskFile) Stats(days, weeks int) (Stats, error) {
+	tasks := append(Tasks(nil), tf.Tasks...)
+	sources := []string{SourceDone}
+	if tf.includes(SourceTrash) {
+		sources = append(sources, SourceTrash)
+	}
+	for _, source := range sources {
+		more, err := tf.readSource(source)
+		if err != nil {
+			return Stats{}, err
+		}
+		tasks = append(tasks, more...)
+	}
+
+	today := Today()
+	var st Stats
+	var leadDays int
+	projects := make(map[string]*GroupStats)
+	contexts := make(map[string]*GroupStats)
+	var counted Tasks
+	for _, t := range tasks {
+		if !tf.Opts.Query.Match(t) {
+			continue
+		}
+		t.setDueStatus(today)
+		counted = append(counted, t)
+		if t.IsDone() {
+			st.Completed++
+			if t.Source == SourceDone {
+				st.Archived++
+			}
+			if !t.Created.IsZero() && !t.Completed.IsZero() {
+				leadDays += daysBetween(t.Created, t.Completed)
+				st.LeadTimeTasks++
+			}
+		} else {
+			st.Open++
+		}
+		if t.Overdue {
+			st.Overdue++
+		}
+		if t.DueToday {
+			st.DueToday++
+		}
+		for _, p := range t.Projects {
+			countGroup(projects, "+"+p, t)
+		}
+		for _, c := range t.Contexts {
+			countGroup(contexts, "@"+c, t)
+		}
+	}
+	if st.LeadTimeTasks > 0 {
+		st.LeadTime = float64(leadDays) / float64(st.LeadTimeTasks)
+	}
+
+	for i := days - 1; i >= 0; i-- {
+		start := today.AddDate(0, 0, -i)
+		st.Days = append(st.Days, periodStats(counted, start, start.AddDate(0, 0, 1)))
+	}
+	monday := today.AddDate(0, 0, -int((today.Weekday()+6)%7))
+	for i := weeks - 1; i >= 0; i-- {
+		start := monday.AddDate(0, 0, -7*i)
+		st.Weeks = append(st.Weeks, periodStats(counted, start, start.AddDate(0, 0, 7)))
+	}
+	st.PerDay = averageCompleted(st.Days)
+	st.PerWeek = averageCompleted(st.Weeks)
+	st.Projects = sortedGroups(projects)
+	st.Contexts = sortedGroups(contexts)
+	return st, nil
+}
+
+func countGroup(groups map[string]*GroupStats, name string, t Task) {
+	g, ok := groups[name]
+	if !ok {
+		g = &GroupStats{Name: name}
+		groups[name] = g
+	}
+	if t.IsDone() {
+		g.Completed++
+	} else {
+		g.Open++
+	}
+	if t.Overdue {
+		g.Overdue++
+	}
+}
+
+func sortedGroups(groups map[string]*GroupStats) []GroupStats {
+	list := make([]GroupStats, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if c := compareNatural(list[i].Name, list[j].Name); c != 0 {
+			return c < 0
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// periodStats counts the tasks created and completed from start up to
+// end, and those open at end. A task with no creation date is taken to
+// have always been there, and a completed one with no completion date to
+// have never been open.
+func periodStats(tasks Tasks, start, end time.Time) PeriodStats {
+	p := PeriodStats{Start: YMD(start)}
+	in := func(d time.Time) bool { return !d.IsZero() && !d.Before(start) && d.Before(end) }
+	for _, t := range tasks {
+		if in(t.Created) {
+			p.Created++
+		}
+		if t.IsDone() && in(t.Completed) {
+			p.Completed++
+		}
+		created := t.Created.IsZero() || t.Created.Before(end)
+		open := !t.IsDone() || !t.Completed.IsZero() && !t.Completed.Before(end)
+		if created && open {
+			p.Open++
+		}
+	}
+	return p
+}
+
+func averageCompleted(periods []PeriodStats) float64 {
+	if len(periods) == 0 {
+		return 0
+	}
+	n := 0
+	for _, p := range periods {
+		n += p.Completed
+	}
+	return float64(n) / float64(len(periods))
+}
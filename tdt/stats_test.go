@@ -0,0 +1,56 @@
+package tdt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	fixClock(t, "2024-05-01") // a Wednesday
+	tf, s := newTestFile(t,
+		"2024-04-28 open one +work @desk due:2024-04-30",
+		"2024-05-01 open two +home due:2024-05-01",
+		"x 2024-04-30 2024-04-27 done one +work pri:A",
+		"no dates",
+	)
+	s.Write("done.txt", []byte("x 2024-04-22 2024-04-20 archived +work\nx 2024-04-29 done without created\n"))
+	st, err := tf.Stats(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := []int{st.Open, st.Completed, st.Archived, st.Overdue, st.DueToday, st.LeadTimeTasks}
+	if want := []int{3, 3, 2, 1, 1, 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("open, completed, archived, overdue, due today, lead time tasks = %v, want %v", counts, want)
+	}
+	if st.LeadTime != 2.5 || st.PerDay != 2.0/3 || st.PerWeek != 1.5 {
+		t.Errorf("lead time %v, per day %v, per week %v, want 2.5, 0.67, 1.5", st.LeadTime, st.PerDay, st.PerWeek)
+	}
+	days := []PeriodStats{
+		{"2024-04-29", 0, 1, 3},
+		{"2024-04-30", 0, 1, 2},
+		{"2024-05-01", 1, 0, 3},
+	}
+	if !reflect.DeepEqual(st.Days, days) {
+		t.Errorf("days = %v, want %v", st.Days, days)
+	}
+	weeks := []PeriodStats{
+		{"2024-04-22", 2, 1, 4},
+		{"2024-04-29", 1, 2, 3},
+	}
+	if !reflect.DeepEqual(st.Weeks, weeks) {
+		t.Errorf("weeks = %v, want %v", st.Weeks, weeks)
+	}
+	projects := []GroupStats{{"+home", 1, 0, 0}, {"+work", 1, 2, 1}}
+	if !reflect.DeepEqual(st.Projects, projects) {
+		t.Errorf("projects = %v, want %v", st.Projects, projects)
+	}
+	contexts := []GroupStats{{"@desk", 1, 0, 1}}
+	if !reflect.DeepEqual(st.Contexts, contexts) {
+		t.Errorf("contexts = %v, want %v", st.Contexts, contexts)
+	}
+
+	tf.Opts.Query, _ = ParseQuery("+work")
+	if st, _ = tf.Stats(0, 0); st.Open != 1 || st.Completed != 2 || len(st.Days) != 0 {
+		t.Errorf("filtered: %d open, %d completed, %d days, want 1, 2, 0", st.Open, st.Completed, len(st.Days))
+	}
+}
@@ -0,0 +1,248 @@
+package tdt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Storage is a backend that task files are read from and written to.
+// Each TaskFile holds its own Storage, so a todo list and its done and
+// trash files need not share one.
+type Storage interface {
+	// Read returns the contents of fn and when it was last modified.
+	// A missing file is reported as ErrNotFound.
+	Read(fn string) ([]byte, time.Time, error)
+	// Write replaces the contents of fn, creating it if needed.
+	Write(fn string, data []byte) error
+	// Append adds data to the end of fn, creating it if needed.
+	Append(fn string, data []byte) error
+	// Stat returns when fn was last modified.
+	Stat(fn string) (time.Time, error)
+	// Watch sends an event on changed each time fn is modified, until
+	// stop is closed.
+	Watch(fn string, changed chan<- FileChangedEvent, stop <-chan struct{}) error
+	// Lock takes an advisory lock on fn that other processes using the
+	// same storage respect, waiting for it if needed. ErrLocked is
+	// returned if it is not released in time.
+	Lock(fn string) error
+	// Unlock releases a lock taken with Lock.
+	Unlock(fn string) error
+}
+
+// DefaultStorage is used by Read and Watch.
+var DefaultStorage Storage = &LocalStorage{}
+
+// BackupStorage is implemented by storages that keep previous versions of
+// the files they write.
+type BackupStorage interface {
+	// ListBackups returns the backups of fn, most recent first.
+	ListBackups(fn string) ([]Backup, error)
+	// RestoreBackup replaces fn with its backup number n.
+	RestoreBackup(fn string, n int) error
+}
+
+type Backup struct {
+	Number  int
+	Path    string
+	ModTime time.Time
+}
+
+// LocalStorage keeps task files on the local disk. Files are replaced
+// atomically, and the previous BackupCount versions of each are kept as
+// fn.1, fn.2 and so on. Locks are held by creating fn.lock.
+type LocalStorage struct {
+	BackupCount int
+	LockTimeout time.Duration
+	locks       lockOwners
+}
+
+func (s *LocalStorage) Read(fn string) ([]byte, time.Time, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, time.Time{}, newFileError("read", fn, err)
+	}
+	modTime, err := s.Stat(fn)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, modTime, nil
+}
+
+func (s *LocalStorage) Write(fn string, data []byte) error {
+	if err := s.rotateBackups(fn, data); err != nil {
+		return newFileError("backup", fn, err)
+	}
+	return newFileError("write", fn, writeFileAtomic(fn, data))
+}
+
+// writeFileAtomic writes data to a temporary file next to fn and renames
+// it over fn, so that fn is never left partly written.
+func writeFileAtomic(fn string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(fn); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(fn), "."+filepath.Base(fn)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// writeWithoutBackup writes fn to s without rotating its backups, for the
+// undo journal and for edits undone in the done and trash files, which
+// would otherwise push out the backups of the user's own changes.
+func writeWithoutBackup(s Storage, fn string, data []byte) error {
+	if _, ok := s.(*LocalStorage); ok {
+		return newFileError("write", fn, writeFileAtomic(fn, data))
+	}
+	return s.Write(fn, data)
+}
+
+func backupName(fn string, n int) string {
+	return fmt.Sprintf("%s.%d", fn, n)
+}
+
+// rotateBackups shifts the backups of fn along by one and copies fn to
+// fn.1, unless writing data would not change fn.
+func (s *LocalStorage) rotateBackups(fn string, data []byte) error {
+	if s.BackupCount <= 0 {
+		return nil
+	}
+	current, err := os.ReadFile(fn)
+	if os.IsNotExist(err) || bytes.Equal(current, data) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for n := s.BackupCount - 1; n >= 1; n-- {
+		err := os.Rename(backupName(fn, n), backupName(fn, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeFileAtomic(backupName(fn, 1), current)
+}
+
+func (s *LocalStorage) ListBackups(fn string) ([]Backup, error) {
+	var backups []Backup
+	for n := 1; ; n++ {
+		name := backupName(fn, n)
+		info, err := os.Stat(name)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, newFileError("stat", name, err)
+		}
+		backups = append(backups, Backup{Number: n, Path: name, ModTime: info.ModTime()})
+	}
+	return backups, nil
+}
+
+// RestoreBackup writes backup n over fn. The version being replaced
+// becomes the most recent backup, so a restore can itself be undone.
+func (s *LocalStorage) RestoreBackup(fn string, n int) error {
+	if err := s.Lock(fn); err != nil {
+		return err
+	}
+	defer s.Unlock(fn)
+	name := backupName(fn, n)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return newFileError("restore", name, err)
+	}
+	return s.Write(fn, data)
+}
+
+func (s *LocalStorage) Append(fn string, data []byte) error {
+	opts := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	f, err := os.OpenFile(fn, opts, 0644)
+	if err != nil {
+		return newFileError("append", fn, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return newFileError("append", fn, err)
+	}
+	if err := f.Sync(); err != nil {
+		return newFileError("append", fn, err)
+	}
+	return newFileError("append", fn, f.Close())
+}
+
+func (s *LocalStorage) Stat(fn string) (time.Time, error) {
+	info, err := os.Stat(fn)
+	if err != nil {
+		return time.Time{}, newFileError("stat", fn, err)
+	}
+	return info.ModTime(), nil
+}
+
+func (s *LocalStorage) Watch(fn string, changed chan<- FileChangedEvent, stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return newFileError("watch", fn, err)
+	}
+	defer watcher.Close()
+	// Watch the directory, as files replaced by renaming (which is how
+	// they are written) drop out of a watch on the file itself.
+	fn = filepath.Clean(fn)
+	err = watcher.Add(filepath.Dir(fn))
+	if err != nil {
+		return newFileError("watch", fn, err)
+	}
+	changing := false
+	for {
+		select {
+		case <-stop:
+			Log(log.Warning, "stop watching "+fn)
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return newFileError("watch", fn, err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != fn {
+				continue
+			}
+			// This works around multiple FSEvents on macOS
+			if !changing {
+				Log(log.Warning, "modified "+fn)
+				changing = true
+				go func() {
+					time.Sleep(time.Second * 4)
+					select {
+					case changed <- FileChangedEvent{EventName: event.Op.String()}:
+					case <-stop:
+					}
+					changing = false
+				}()
+			}
+		}
+	}
+}
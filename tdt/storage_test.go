@@ -0,0 +1,123 @@
+package tdt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readString(t *testing.T, fn string) string {
+	t.Helper()
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "todo.txt")
+	if err := os.WriteFile(fn, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(fn, []byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, fn); got != "new\n" {
+		t.Errorf("file after write = %q, want %q", got, "new\n")
+	}
+	if info, _ := os.Stat(fn); info.Mode().Perm() != 0600 {
+		t.Errorf("file mode after write = %v, want 0600", info.Mode().Perm())
+	}
+
+	// The rename fails when a directory is in the way, which must leave
+	// it as it was and no temporary file behind
+	blocked := filepath.Join(dir, "blocked.txt")
+	if err := os.MkdirAll(filepath.Join(blocked, "inside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(blocked, []byte("new\n")); err == nil {
+		t.Fatal("write over a directory succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(blocked, "inside")); err != nil {
+		t.Errorf("failed write changed what it was replacing: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files after a failed write: %q, want no temporary file", names)
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "todo.txt")
+	s := &LocalStorage{BackupCount: 2}
+	for _, v := range []string{"v1\n", "v2\n", "v3\n", "v4\n", "v4\n"} {
+		if err := s.Write(fn, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{fn: "v4\n", backupName(fn, 1): "v3\n", backupName(fn, 2): "v2\n"}
+	for name, v := range want {
+		if got := readString(t, name); got != v {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, v)
+		}
+	}
+	if _, err := os.Stat(backupName(fn, 3)); !os.IsNotExist(err) {
+		t.Errorf("backup past the limit of 2 was kept: %v", err)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "todo.txt")
+	s := &LocalStorage{BackupCount: 3}
+	for _, v := range []string{"v1\n", "v2\n", "v3\n"} {
+		if err := s.Write(fn, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := s.ListBackups(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Number != 1 || backups[1].Path != backupName(fn, 2) {
+		t.Fatalf("ListBackups = %+v, want backups 1 and 2", backups)
+	}
+
+	if err := s.RestoreBackup(fn, 2); err != nil {
+		t.Fatal(err)
+	}
+	// The version replaced becomes the most recent backup
+	want := map[string]string{fn: "v1\n", backupName(fn, 1): "v3\n", backupName(fn, 2): "v2\n", backupName(fn, 3): "v1\n"}
+	for name, v := range want {
+		if got := readString(t, name); got != v {
+			t.Errorf("%s after restoring = %q, want %q", filepath.Base(name), got, v)
+		}
+	}
+	if _, err := os.Stat(lockName(fn)); !os.IsNotExist(err) {
+		t.Errorf("lock left after restoring: %v", err)
+	}
+	if err := s.RestoreBackup(fn, 9); !isNotFound(err) {
+		t.Errorf("RestoreBackup of a missing backup = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWebdavTmpFile(t *testing.T) {
+	a := NewWebdavStorage("https://one.example/dav", "u", "p", "/tmp")
+	b := NewWebdavStorage("https://two.example/dav", "u", "p", "/tmp")
+	names := map[string]bool{
+		a.tmpFile("work/todo.txt"): true,
+		a.tmpFile("home/todo.txt"): true,
+		b.tmpFile("work/todo.txt"): true,
+	}
+	if len(names) != 3 {
+		t.Errorf("temp files shared between files: %v", names)
+	}
+	if a.tmpFile("work/todo.txt") != a.tmpFile("work/todo.txt") {
+		t.Errorf("temp file for the same file changes")
+	}
+}
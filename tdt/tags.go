@@ -0,0 +1,84 @@
+package tdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Get returns the value of the first tag with the given key, ignoring
+// case.
+func (ts Tags) Get(key string) (string, bool) {
+	for _, t := range ts {
+		if strings.EqualFold(t.Key, key) {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// MarshalJSON writes tags as an object, keeping their order. Only the
+// first of several tags with the same key is included.
+func (ts Tags) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	seen := make(map[string]bool)
+	for _, t := range ts {
+		if seen[t.Key] {
+			continue
+		}
+		seen[t.Key] = true
+		if len(seen) > 1 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Tag returns the value of the task's tag with the given key.
+func (t *Task) Tag(key string) (string, bool) {
+	return t.Tags.Get(key)
+}
+
+// compareTagValues orders tag values numerically if both are numbers, and
+// as strings otherwise. A number comes before anything else, so that the
+// order is the same whichever values are compared.
+func compareTagValues(a, b string) int {
+	x, okA := tagNumber(a)
+	y, okB := tagNumber(b)
+	switch {
+	case okA && okB:
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case okA != okB:
+		if okA {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func tagNumber(s string) (float64, bool) {
+	x, err := strconv.ParseFloat(s, 64)
+	return x, err == nil && !math.IsNaN(x)
+}
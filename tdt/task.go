@@ -25,13 +25,9 @@ package tdt
 import (
 	"fmt"
 	"strings"
-	"time"
-
-	"github.com/jinzhu/copier"
 )
 
 var (
-	todoFile = "todo.txt"
 	SortFile = false
 )
 
@@ -45,67 +41,257 @@ func (tf *TaskFile) findTask(lineNumber int) (int, Task) {
 	return -1, Task{}
 }
 
+// nextLineNumber returns a line number not used by any task, nor by any
+// line of the file as it was last read.
+func (tf *TaskFile) nextLineNumber() int {
+	next := 0
+	for _, t := range tf.Tasks {
+		if t.LineNumber >= next {
+			next = t.LineNumber + 1
+		}
+	}
+	for _, b := range tf.base {
+		if b.LineNumber >= next {
+			next = b.LineNumber + 1
+		}
+	}
+	return next
+}
+
 func (tf *TaskFile) Add(line string) *TaskFile {
-	t, err := parseTask(line)
+	tf.record("add", func() []FileChange {
+		tf.add(line)
+		return nil
+	})
+	return tf
+}
+
+func (tf *TaskFile) add(line string) {
+	t, err := parseNewTask(line)
 	if err != nil {
-		return tf
+		return
 	}
-	t.Created = time.Now()
-	today := YMD(t.Created)
-	if t.Priority == "z" {
-		t.original = today + " " + strings.TrimSpace(t.original)
-	} else {
-		fields := strings.Fields(t.original)
-		line = strings.Join(fields[1:], " ")
-		t.original = fmt.Sprintf("(%s) %s %s", t.Priority, today, line)
+	t.setCreated(Today())
+	if t.ID == "" && tf.Opts.AutoID {
+		setID(&t, tf.newID())
 	}
+	t.LineNumber = tf.nextLineNumber()
 	tf.Tasks = append(tf.Tasks, t)
-	t.LineNumber = len(tf.Tasks)
-	return tf
 }
 
-func (tf *TaskFile) Archive() *TaskFile {
-	done := TaskFile{Path: getRelativeFileName("done")}
-	var pending Tasks
+// Archive moves completed tasks to the done file, and writes tf.
+func (tf *TaskFile) Archive() error {
+	before := tf.snapshot()
+	var moved Tasks
+	tf.record("archive", func() []FileChange {
+		moved = tf.archive()
+		if len(moved) == 0 {
+			return nil
+		}
+		return []FileChange{{Path: tf.DoneFile.Path, Added: taskLines(moved)}}
+	})
+	return tf.moveOut(tf.DoneFile, moved, before)
+}
+
+// archive takes the completed tasks out of tf, and returns them.
+func (tf *TaskFile) archive() Tasks {
+	var done, pending Tasks
 	for _, t := range tf.Tasks {
 		if t.IsDone() {
-			done.Tasks = append(done.Tasks, t)
+			done = append(done, t)
 		} else {
 			pending = append(pending, t)
 		}
 	}
 	tf.Tasks = pending
-	done.write(true)
-	return tf
+	return done
+}
+
+// Delete moves the tasks on the given lines to the trash file, and writes
+// tf.
+func (tf *TaskFile) Delete(nums ...int) error {
+	before := tf.snapshot()
+	var moved Tasks
+	tf.record("delete", func() []FileChange {
+		moved = tf.delete(nums...)
+		if len(moved) == 0 {
+			return nil
+		}
+		return []FileChange{{Path: tf.TrashFile.Path, Added: taskLines(moved)}}
+	})
+	return tf.moveOut(tf.TrashFile, moved, before)
 }
 
-func (tf *TaskFile) Delete(nums ...int) *TaskFile {
+// delete takes the tasks on the given lines out of tf, tagged with the
+// date, and returns them.
+func (tf *TaskFile) delete(nums ...int) Tasks {
+	deleted := make(map[int]bool)
 	for _, num := range nums {
-		i, t := tf.findTask(num)
-		t.Deleted = true
-		tf.Tasks[i] = t
+		if i, _ := tf.findTask(num); i >= 0 {
+			deleted[num] = true
+		}
 	}
-	trash := TaskFile{Path: getRelativeFileName("trash")}
-	var pending Tasks
+	var trash, pending Tasks
 	for _, t := range tf.Tasks {
-		if t.Deleted {
-			trash.Tasks = append(trash.Tasks, t)
+		if deleted[t.LineNumber] {
+			t.Deleted = true
+			t.setTagValue(deletedTag, YMD(Today()))
+			trash = append(trash, t)
 		} else {
 			pending = append(pending, t)
 		}
 	}
 	tf.Tasks = pending
-	trash.write(true)
-	return tf
+	return trash
+}
+
+// moveOut appends the tasks just moved out of tf to side, and then writes
+// tf. If tf can't be written they are taken out of side again and tf is
+// put back as it was before, so that a failure part way leaves the tasks
+// in two files rather than in none.
+func (tf *TaskFile) moveOut(side *TaskFile, moved Tasks, before snapshot) error {
+	if len(moved) == 0 {
+		return nil
+	}
+	out := TaskFile{Path: side.Path, Storage: side.Storage, Tasks: moved}
+	if err := out.write(true); err != nil {
+		tf.rollback(before)
+		return err
+	}
+	if err := tf.Write(); err != nil {
+		tf.rollback(before)
+		if err := editLines(side.Storage, side.Path, taskLines(moved), nil); err != nil {
+			Log(log.Error, "could not take back lines added to "+side.Path+": "+err.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// deletedTag holds the date a task was moved to the trash file.
+const deletedTag = "deleted"
+
+// Restore moves the tasks on the given lines of the done or trash file,
+// as numbered by SourceLineNumbers, back to the end of tf, and writes tf.
+// Tasks from the trash file lose their deleted: tag. The lines are only
+// removed from the done or trash file once tf has been written, so that
+// a failed write leaves them where they were.
+func (tf *TaskFile) Restore(source string, nums ...int) (err error) {
+	before := tf.snapshot()
+	var moved []string
+	tf.record("restore", func() []FileChange {
+		moved, err = tf.restore(source, nums...)
+		if len(moved) == 0 {
+			return nil
+		}
+		return []FileChange{{Path: tf.sourceFile(source).Path, Removed: moved}}
+	})
+	if err != nil || len(moved) == 0 {
+		return err
+	}
+	if err := tf.Write(); err != nil {
+		tf.rollback(before)
+		return err
+	}
+	side := tf.sourceFile(source)
+	return editLines(side.Storage, side.Path, moved, nil)
+}
+
+// restore adds the tasks to be moved back from the done or trash file to
+// tf, and returns their lines there.
+func (tf *TaskFile) restore(source string, nums ...int) ([]string, error) {
+	tasks, err := tf.readSource(source)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int]bool)
+	for _, num := range nums {
+		wanted[num] = true
+	}
+	var moved []string
+	for _, t := range tasks {
+		if !wanted[t.LineNumber] {
+			continue
+		}
+		moved = append(moved, t.original)
+		t.removeTagValue(deletedTag)
+		t.Source = ""
+		t.Deleted = false
+		t.LineNumber = tf.nextLineNumber()
+		tf.Tasks = append(tf.Tasks, t)
+	}
+	return moved, nil
+}
+
+// Purge removes the tasks deleted at least days days ago from the trash
+// file for good, and returns how many there were. Tasks deleted before
+// deleted: tags were added have no date, and are only purged when days is
+// zero, which empties the trash. days can't be negative.
+func (tf *TaskFile) Purge(days int) (n int, err error) {
+	if days < 0 {
+		return 0, fmt.Errorf("purge: days must be 0 or more, not %d", days)
+	}
+	tf.record("purge", func() []FileChange {
+		var purged []string
+		purged, err = tf.purge(days)
+		n = len(purged)
+		if n == 0 {
+			return nil
+		}
+		return []FileChange{{Path: tf.TrashFile.Path, Removed: purged}}
+	})
+	return n, err
+}
+
+func (tf *TaskFile) purge(days int) ([]string, error) {
+	tasks, err := tf.readSource(SourceTrash)
+	if err != nil {
+		return nil, err
+	}
+	today := Today()
+	var purged []string
+	for _, t := range tasks {
+		if days > 0 {
+			value, ok := t.Tag(deletedTag)
+			if !ok {
+				continue
+			}
+			deleted, err := parseYMD(value)
+			if err != nil || daysBetween(deleted, today) < days {
+				continue
+			}
+		}
+		purged = append(purged, t.original)
+	}
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	if err := editLines(tf.TrashFile.Storage, tf.TrashFile.Path, purged, nil); err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// taskLines returns the lines of tasks as they are written to a file.
+func taskLines(tasks Tasks) []string {
+	lines := make([]string, len(tasks))
+	for i, t := range tasks {
+		lines[i] = t.original
+	}
+	return lines
 }
 
 func (tf *TaskFile) Edit(changes string, force bool, nums ...int) *TaskFile {
 	p, t, d, r := parseChanges(changes)
 	// Logf(log.Debugf, "%s - pri:%s t:%s due:%s rec:%s", changes, p, t, d, r)
-	tf.setPriorities(p, nums...).
-		setThresholds(t, force, nums...).
-		setDueDates(d, force, nums...).
-		setRecurrence(r, nums...)
+	tf.record("edit", func() []FileChange {
+		tf.setPriorities(p, nums...).
+			setDueDates(d, force, nums...).
+			setThresholds(t, force, nums...).
+			setRecurrence(r, nums...).
+			fixDates(nums...)
+		return nil
+	})
 	return tf
 }
 
@@ -113,16 +299,38 @@ func (tf *TaskFile) Replace(replace string, num int) *TaskFile {
 	if replace == "" {
 		return tf
 	}
+	tf.record("replace", func() []FileChange {
+		tf.replace(replace, num)
+		return nil
+	})
+	return tf
+}
+
+func (tf *TaskFile) replace(replace string, num int) {
 	i, t := tf.findTask(num)
-	if !t.IsDone() {
-		t, err := parseTask(replace)
+	if i >= 0 && !t.IsDone() {
+		t, err := parseNewTask(replace)
 		// Logf(log.Debugf, "%+v", t)
 		if err != nil {
-			return tf
+			return
 		}
-		// Logf(log.Debugf, "%+v", t)
+		if id := tf.Tasks[i].ID; id != "" && t.ID == "" {
+			setID(&t, id)
+		}
+		t.LineNumber = num
 		tf.Tasks[i] = t
 	}
+}
+
+// fixDates writes relative dates in the given tasks, such as due:fri, as
+// the dates they stand for.
+func (tf *TaskFile) fixDates(nums ...int) *TaskFile {
+	for _, num := range nums {
+		if i, t := tf.findTask(num); i >= 0 && !t.IsDone() {
+			t.fixDates()
+			tf.Tasks[i] = t
+		}
+	}
 	return tf
 }
 
@@ -133,23 +341,18 @@ func (tf *TaskFile) setThresholds(threshold string, force bool, nums ...int) *Ta
 	threshold = strings.ToLower(threshold)
 	for _, num := range nums {
 		i, t := tf.findTask(num)
-		if !t.IsDone() {
+		if i >= 0 && !t.IsDone() {
 			if threshold == "x" {
-				t.original = strings.TrimSpace(ThresholdRegex.ReplaceAllString(t.original, " "))
-				t.HasThreshold = false
+				t.removeTag(TokenThreshold)
 			} else if t.HasThreshold {
 				// Log(log.Debug, t.Threshold)
-				d := parseNewDate(threshold, t.Threshold)
-				t.original = strings.ReplaceAll(t.original,
-					"t:"+YMD(t.Threshold),
-					"t:"+YMD(d))
-				t.Threshold = d
-				t.HasThreshold = true
+				if d, ok := parseThreshold(threshold, t.Threshold, &t); ok {
+					t.setThreshold(d)
+				}
 			} else if force {
-				d := parseNewDate(threshold, time.Now())
-				t.original = t.original + " t:" + YMD(d)
-				t.Threshold = d
-				t.HasThreshold = true
+				if d, ok := parseThreshold(threshold, Today(), &t); ok {
+					t.setThreshold(d)
+				}
 			}
 			tf.Tasks[i] = t
 		}
@@ -164,22 +367,17 @@ func (tf *TaskFile) setDueDates(due string, force bool, nums ...int) *TaskFile {
 	due = strings.ToLower(due)
 	for _, num := range nums {
 		i, t := tf.findTask(num)
-		if !t.IsDone() {
+		if i >= 0 && !t.IsDone() {
 			if due == "x" {
-				t.original = strings.TrimSpace(DueRegex.ReplaceAllString(t.original, " "))
-				t.HasDue = false
+				t.removeTag(TokenDue)
 			} else if t.HasDue {
-				d := parseNewDate(due, t.Due)
-				t.original = strings.ReplaceAll(t.original,
-					"due:"+YMD(t.Due),
-					"due:"+YMD(d))
-				t.Due = d
-				t.HasDue = true
+				if d, ok := parseDate(due, t.Due); ok {
+					t.setDue(d)
+				}
 			} else if force {
-				d := parseNewDate(due, time.Now())
-				t.original = t.original + " due:" + YMD(d)
-				t.Due = d
-				t.HasDue = true
+				if d, ok := parseDate(due, Today()); ok {
+					t.setDue(d)
+				}
 			}
 			tf.Tasks[i] = t
 		}
@@ -194,22 +392,15 @@ func (tf *TaskFile) setRecurrence(rec string, nums ...int) *TaskFile {
 	rec = strings.ToLower(rec)
 	for _, num := range nums {
 		i, t := tf.findTask(num)
-		if !t.IsDone() {
+		if i >= 0 && !t.IsDone() {
 			if rec == "x" {
-				t.original = strings.TrimSpace(RecurrenceRegex.ReplaceAllString(t.original, " "))
+				t.removeTag(TokenRecurrence)
 			} else {
 				if !t.HasDue && !t.HasThreshold {
 					// There's not much point to adding recurrence without dates
 					continue
 				}
-				r := newRecurrence(rec)
-				if t.Recurrence.Period != "" {
-					t.original = strings.ReplaceAll(t.original,
-						"rec:"+t.Recurrence.String,
-						"rec:"+r.String)
-				} else {
-					t.original += " rec:" + r.String
-				}
+				t.setTag(TokenRecurrence, "rec", newRecurrence(rec).String)
 			}
 			tf.Tasks[i] = t
 		}
@@ -224,17 +415,11 @@ func (tf *TaskFile) setPriorities(pri string, nums ...int) *TaskFile {
 	pri = strings.ToUpper(pri)
 	for _, num := range nums {
 		i, t := tf.findTask(num)
-		if !t.IsDone() {
+		if i >= 0 && !t.IsDone() {
 			if pri == "x" {
-				t.original = strings.TrimSpace(PriorityRegex.ReplaceAllString(t.original, ""))
-				t.Priority = "z"
-			} else if t.Priority == "z" {
-				t.Priority = pri
-				t.original = fmt.Sprintf("(%s) %s", pri, t.original)
+				t.setPriority("")
 			} else {
-				t.Priority = pri
-				parts := strings.Fields(t.original)
-				t.original = fmt.Sprintf("(%s) %s", pri, strings.Join(parts[1:], " "))
+				t.setPriority(pri)
 			}
 			tf.Tasks[i] = t
 		}
@@ -242,59 +427,77 @@ func (tf *TaskFile) setPriorities(pri string, nums ...int) *TaskFile {
 	return tf
 }
 
-func (tf *TaskFile) Toggle(nums ...int) *TaskFile {
+// Toggle marks tasks as done, or as not done if they are. Completing a
+// recurring task adds its next instance, which is linked to it by a next:
+// tag holding the new task's id. Marking the task as not done again
+// removes that instance, unless keepNext is true or the instance has been
+// completed too.
+func (tf *TaskFile) Toggle(keepNext bool, nums ...int) *TaskFile {
+	tf.record("toggle", func() []FileChange {
+		tf.toggle(keepNext, nums...)
+		return nil
+	})
+	return tf
+}
+
+func (tf *TaskFile) toggle(keepNext bool, nums ...int) {
 	for _, num := range nums {
 		i, t := tf.findTask(num)
-		if t.IsDone() {
-			t.Done = 0
-			parts := strings.Fields(t.original)
-			t.original = strings.Join(parts[2:], " ")
-			tf.Tasks[i] = t
-			break
+		if i < 0 {
+			continue
 		}
-		if t.Recurrence.Period != "" {
-			var n Task
-			copier.Copy(&n, &t)
-			// Log(log.Debug, "1: "+n.Original)
-			parts := strings.Fields(n.original)
-			today := YMD(time.Now())
-			if n.Priority == "z" {
-				n.original = today + " " + strings.Join(parts[1:], " ")
-				// Log(log.Debug, "2: "+n.Original)
-			} else {
-				n.original = fmt.Sprintf("(%s) %s %s", t.Priority, today,
-					strings.Join(parts[2:], " "))
-				// Log(log.Debug, "3: "+n.Original)
-			}
-			if n.HasDue {
-				d := n.Recurrence.getNextDate(n.Due)
-				n.original = strings.ReplaceAll(n.original,
-					" due:"+YMD(n.Due),
-					" due:"+YMD(d))
-				n.Due = d
-				// Log(log.Debug, "4: "+n.Original)
+		if t.IsDone() {
+			next, linked := t.Tag(nextTag)
+			if linked {
+				t.removeTagValue(nextTag)
 			}
-			if n.HasThreshold {
-				d := n.Recurrence.getNextDate(n.Threshold)
-				n.original = strings.ReplaceAll(n.original,
-					" t:"+YMD(n.Threshold),
-					" t:"+YMD(d))
-				n.Threshold = d
-				// Log(log.Debug, "5: "+n.Original)
+			t.uncomplete()
+			tf.Tasks[i] = t
+			// Removing the next instance moves the tasks after it, so it
+			// comes after the task is put back at i
+			if linked && !keepNext {
+				tf.removeNext(next)
 			}
-			n.Created = time.Now()
-			// Log(log.Debug, "6: "+n.Original)
-			// Log(log.Warning, len(tasks))
+			continue
+		}
+		if n, ok := t.nextInstance(); ok {
+			n.setCreated(Today())
+			setID(&n, tf.newID())
+			n.LineNumber = tf.nextLineNumber()
 			tf.Tasks = append(tf.Tasks, n)
-			n.LineNumber = len(tf.Tasks)
-			// Log(log.Warning, len(tasks))
+			t.setTagValue(nextTag, n.ID)
 		}
-		t.Completed = time.Now()
-		t.Done = 1
-		t.original = "x " + YMD(t.Completed) + " " + t.original
+		t.fixDates()
+		t.complete(Today())
 		tf.Tasks[i] = t
 	}
-	return tf
+}
+
+// nextTag links a completed recurring task to its next instance.
+const nextTag = "next"
+
+// NextInstances returns the line numbers of the next instances that
+// toggling the given tasks would remove.
+func (tf *TaskFile) NextInstances(nums ...int) []int {
+	var next []int
+	for _, num := range nums {
+		if i, t := tf.findTask(num); i >= 0 && t.IsDone() {
+			if id, ok := t.Tag(nextTag); ok {
+				if j, n := tf.findID(id); j >= 0 && !n.IsDone() {
+					next = append(next, n.LineNumber)
+				}
+			}
+		}
+	}
+	return next
+}
+
+// removeNext removes the next instance with the given id, if it is still
+// in the file and not done.
+func (tf *TaskFile) removeNext(id string) {
+	if i, n := tf.findID(id); i >= 0 && !n.IsDone() {
+		tf.Tasks = append(tf.Tasks[:i], tf.Tasks[i+1:]...)
+	}
 }
 
 func (t *Task) IsDone() bool {
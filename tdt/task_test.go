@@ -0,0 +1,441 @@
+package tdt
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixClock makes the current time noon on day for the rest of the test.
+func fixClock(t *testing.T, day string) {
+	t.Helper()
+	d := date(day).Add(12 * time.Hour)
+	SetClock(func() time.Time { return d })
+	t.Cleanup(func() { SetClock(nil) })
+}
+
+// newTestFile returns a task file holding lines, kept in memory.
+func newTestFile(t *testing.T, lines ...string) (*TaskFile, *MemoryStorage) {
+	t.Helper()
+	s := NewMemoryStorage()
+	data := ""
+	for _, line := range lines {
+		data += line + "\n"
+	}
+	s.Write("todo.txt", []byte(data))
+	tf, err := ReadFrom(s, "todo.txt", Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tf, s
+}
+
+// fileLines returns the lines of fn in s.
+func fileLines(t *testing.T, s Storage, fn string) []string {
+	t.Helper()
+	data, _, err := s.Read(fn)
+	if isNotFound(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// originals returns the lines of tf's tasks, in order.
+func originals(tf *TaskFile) []string {
+	var lines []string
+	for _, task := range tf.Tasks {
+		lines = append(lines, task.original)
+	}
+	return lines
+}
+
+func equalLines(a, b []string) bool {
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
+
+func TestAdd(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, _ := newTestFile(t, "existing")
+	tf.Add("plain").Add("(B) with priority due:tomorrow").Add("")
+	want := []string{"existing", "2024-05-01 plain", "(B) 2024-05-01 with priority due:2024-05-02"}
+	if got := originals(tf); !equalLines(got, want) {
+		t.Errorf("after Add: %q, want %q", got, want)
+	}
+	seen := make(map[int]bool)
+	for _, task := range tf.Tasks {
+		if seen[task.LineNumber] {
+			t.Errorf("line number %d used twice", task.LineNumber)
+		}
+		seen[task.LineNumber] = true
+	}
+
+	tf.Opts.AutoID = true
+	tf.Add("with id").Add("own id:mine")
+	if id := tf.Tasks[3].ID; len(id) != 5 || !strings.HasSuffix(tf.Tasks[3].original, " id:"+id) {
+		t.Errorf("auto id task = %q", tf.Tasks[3].original)
+	}
+	if id := tf.Tasks[4].ID; id != "mine" {
+		t.Errorf("task with its own id got id %q", id)
+	}
+}
+
+func TestNewIDWithoutRandom(t *testing.T) {
+	randRead = func([]byte) (int, error) { return 0, errors.New("no entropy") }
+	t.Cleanup(func() { randRead = rand.Read })
+	tf, _ := newTestFile(t)
+	tf.Opts.AutoID = true
+	tf.Add("one").Add("two")
+	a, b := tf.Tasks[0].ID, tf.Tasks[1].ID
+	if len(a) != 5 || len(b) != 5 || a == b {
+		t.Errorf("ids without a random source = %q, %q", a, b)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, _ := newTestFile(t,
+		"(A) has dates t:2024-05-10 due:2024-05-12",
+		"no dates",
+		"x 2024-04-01 done due:2024-04-01",
+	)
+	tf.Edit("(C) due:+1d t:+1w", false, 0, 1, 2)
+	want := []string{
+		"(C) has dates t:2024-05-17 due:2024-05-13",
+		"(C) no dates",
+		"x 2024-04-01 done due:2024-04-01",
+	}
+	if got := originals(tf); !equalLines(got, want) {
+		t.Errorf("after Edit: %q, want %q", got, want)
+	}
+	tf.Edit("due:1d rec:1w", true, 1)
+	if got, want := tf.Tasks[1].original, "(C) no dates due:2024-05-02 rec:1w"; got != want {
+		t.Errorf("after forced Edit: %q, want %q", got, want)
+	}
+	tf.Edit("due:tomorrow", false, 1)
+	if got, want := tf.Tasks[1].original, "(C) no dates due:2024-05-02 rec:1w"; got != want {
+		t.Errorf("after forced Edit: %q, want %q", got, want)
+	}
+	// Edit drops dates that don't parse, so this one is set directly
+	tf.setDueDates("someday", true, 1)
+	if got, want := tf.Tasks[1].original, "(C) no dates due:2024-05-02 rec:1w"; got != want {
+		t.Errorf("after setting a bad due date: %q, want %q", got, want)
+	}
+	tf.Edit("due:x rec:x", true, 0, 1)
+	want = []string{"(C) has dates t:2024-05-17", "(C) no dates"}
+	if got := originals(tf)[:2]; !equalLines(got, want) {
+		t.Errorf("after clearing: %q, want %q", got, want)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tf, _ := newTestFile(t, "first id:abc", "second", "x 2024-01-01 done")
+	tf.Replace("(A) new first", 0).Replace("", 1).Replace("changed", 2).Replace("missing", 9)
+	want := []string{"(A) new first id:abc", "second", "x 2024-01-01 done"}
+	if got := originals(tf); !equalLines(got, want) {
+		t.Errorf("after Replace: %q, want %q", got, want)
+	}
+	if tf.Tasks[0].LineNumber != 0 {
+		t.Errorf("replaced task moved to line %d", tf.Tasks[0].LineNumber)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, _ := newTestFile(t, "(A) 2024-04-01 open", "x 2024-04-30 closed", "other")
+	tf.Toggle(false, 0, 1)
+	want := []string{"x 2024-05-01 2024-04-01 open pri:A", "closed", "other"}
+	if got := originals(tf); !equalLines(got, want) {
+		t.Errorf("after Toggle: %q, want %q", got, want)
+	}
+	if !tf.Tasks[0].IsDone() || !tf.Tasks[0].Completed.Equal(date("2024-05-01")) || tf.Tasks[1].IsDone() {
+		t.Errorf("Toggle did not update Done and Completed")
+	}
+	if tf.Tasks[0].Priority != "A" {
+		t.Errorf("completed task has priority %q, want it kept from the pri: tag", tf.Tasks[0].Priority)
+	}
+}
+
+func TestToggleRecurringNewInstance(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, _ := newTestFile(t,
+		"(B) 2024-04-01 strict t:2024-04-28 due:2024-04-30 rec:+1w id:aaaaa",
+		"loose due:2024-04-20 rec:3d",
+	)
+	tf.Toggle(false, 0, 1)
+	if len(tf.Tasks) != 4 {
+		t.Fatalf("got %d tasks, want 4: %q", len(tf.Tasks), originals(tf))
+	}
+	strict, loose := tf.Tasks[2], tf.Tasks[3]
+	if want := "(B) 2024-05-01 strict t:2024-05-05 due:2024-05-07 rec:+1w id:"; !strings.HasPrefix(strict.original, want) {
+		t.Errorf("next strict instance = %q, want %q...", strict.original, want)
+	}
+	if strict.ID == "aaaaa" || strict.ID == "" {
+		t.Errorf("next instance has id %q, want a new one", strict.ID)
+	}
+	if got, want := loose.original, "2024-05-01 loose due:2024-05-04 rec:3d id:"+loose.ID; got != want || loose.ID == "" {
+		t.Errorf("next loose instance = %q, want %q", got, want)
+	}
+	for i, n := range []Task{strict, loose} {
+		if next, _ := tf.Tasks[i].Tag("next"); next != n.ID {
+			t.Errorf("completed task %d links to %q, want %q", i, next, n.ID)
+		}
+	}
+}
+
+func TestToggleBackRecurring(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	const line = "(B) 2024-04-01 water plants due:2024-04-30 rec:1w"
+	tests := []struct {
+		name     string
+		keepNext bool
+		// doneNext completes the next instance before toggling back
+		doneNext bool
+		want     int
+	}{
+		{"remove next", false, false, 1},
+		{"keep next", true, false, 2},
+		// Completing the next instance added another
+		{"next is done", false, true, 3},
+	}
+	for _, tt := range tests {
+		tf, _ := newTestFile(t, line)
+		tf.Toggle(false, 0)
+		if tt.doneNext {
+			tf.Toggle(false, 1)
+		}
+		next := tf.NextInstances(0)
+		if tt.doneNext != (len(next) == 0) {
+			t.Errorf("%s: NextInstances = %v", tt.name, next)
+		}
+		tf.Toggle(tt.keepNext, 0)
+		if len(tf.Tasks) != tt.want {
+			t.Errorf("%s: got %q, want %d tasks", tt.name, originals(tf), tt.want)
+		}
+		if got := tf.Tasks[0].original; got != line {
+			t.Errorf("%s: toggled back to %q, want %q", tt.name, got, line)
+		}
+	}
+}
+
+func TestToggleBackRecurringSorted(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	const line = "2024-04-01 water plants due:2024-04-30 rec:1w"
+	tf, _ := newTestFile(t, "first", line)
+	tf.Toggle(false, 1)
+	// Open tasks first puts the next instance before the completed task
+	tf.sort("done")
+	if got := tf.Tasks[2].LineNumber; got != 1 {
+		t.Fatalf("completed task sorted to line %d, want last", got)
+	}
+	tf.Toggle(false, 1)
+	if got, want := originals(tf), []string{"first", line}; !equalLines(got, want) {
+		t.Errorf("toggled back to %q, want %q", got, want)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "open", "x 2024-04-30 closed", "x 2024-04-29 also closed")
+	s.Write("done.txt", []byte("x 2024-01-01 earlier\n"))
+	if err := tf.Archive(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := originals(tf), []string{"open"}; !equalLines(got, want) {
+		t.Errorf("tasks after Archive: %q, want %q", got, want)
+	}
+	want := []string{"x 2024-01-01 earlier", "x 2024-04-30 closed", "x 2024-04-29 also closed"}
+	if got := fileLines(t, s, "done.txt"); !equalLines(got, want) {
+		t.Errorf("done.txt after Archive: %q, want %q", got, want)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"open"}; !equalLines(got, want) {
+		t.Errorf("todo.txt after Archive: %q, want %q", got, want)
+	}
+}
+
+func TestArchiveFailedWrite(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	lines := []string{"open", "x 2024-04-30 closed"}
+	tf, s := newTestFile(t, lines...)
+	s.Write("done.txt", []byte("x 2024-01-01 earlier\n"))
+	tf.Storage = &failingStorage{s, "todo.txt", 0}
+	if err := tf.Archive(); err == nil {
+		t.Fatal("Archive succeeded with a failing write")
+	}
+	if got := originals(tf); !equalLines(got, lines) {
+		t.Errorf("tasks after a failed Archive: %q, want %q", got, lines)
+	}
+	if got, want := fileLines(t, s, "done.txt"), []string{"x 2024-01-01 earlier"}; !equalLines(got, want) {
+		t.Errorf("done.txt after a failed Archive: %q, want %q", got, want)
+	}
+
+	// Archiving again once the file can be written moves the task once
+	tf.Storage = s
+	if err := tf.Archive(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, s, "done.txt"), []string{"x 2024-01-01 earlier", "x 2024-04-30 closed"}; !equalLines(got, want) {
+		t.Errorf("done.txt after archiving again: %q, want %q", got, want)
+	}
+	if op, err := tf.Undo(); err != nil || op.Name != "archive" {
+		t.Fatalf("Undo = %v, %v; want archive", op.Name, err)
+	}
+	if got := fileLines(t, s, "todo.txt"); !equalLines(got, lines) {
+		t.Errorf("todo.txt after undoing archive: %q, want %q", got, lines)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "keep", "remove me", "remove me too", "keep too")
+	if err := tf.Delete(1, 2, 99); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := originals(tf), []string{"keep", "keep too"}; !equalLines(got, want) {
+		t.Errorf("tasks after Delete: %q, want %q", got, want)
+	}
+	if got, want := fileLines(t, s, "trash.txt"), []string{"remove me deleted:2024-05-01", "remove me too deleted:2024-05-01"}; !equalLines(got, want) {
+		t.Errorf("trash.txt after Delete: %q, want %q", got, want)
+	}
+	if got, want := fileLines(t, s, "todo.txt"), []string{"keep", "keep too"}; !equalLines(got, want) {
+		t.Errorf("todo.txt after Delete: %q, want %q", got, want)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "keep", "remove me", "keep too")
+	s.Write("done.txt", []byte("x 2024-04-01 archived\nx 2024-04-02 archived too\n"))
+	if err := tf.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	nums, err := tf.SourceLineNumbers(SourceTrash, "t0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tf.Restore(SourceTrash, nums...); err != nil {
+		t.Fatal(err)
+	}
+	if nums, err = tf.SourceLineNumbers(SourceDone, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tf.Restore(SourceDone, nums...); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"keep", "keep too", "remove me", "x 2024-04-02 archived too"}
+	if got := originals(tf); !equalLines(got, want) {
+		t.Errorf("tasks after Restore: %q, want %q", got, want)
+	}
+	if got := fileLines(t, s, "trash.txt"); len(got) != 0 {
+		t.Errorf("trash.txt after Restore: %q", got)
+	}
+	if got, want := fileLines(t, s, "done.txt"), []string{"x 2024-04-01 archived"}; !equalLines(got, want) {
+		t.Errorf("done.txt after Restore: %q, want %q", got, want)
+	}
+	if _, err := tf.SourceLineNumbers(SourceDone, "d5"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SourceLineNumbers(d5) = %v, want ErrNotFound", err)
+	}
+
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, s, "done.txt"), []string{"x 2024-04-01 archived", "x 2024-04-02 archived too"}; !equalLines(got, want) {
+		t.Errorf("done.txt after undoing Restore: %q, want %q", got, want)
+	}
+	if got, want := originals(tf), []string{"keep", "keep too", "remove me"}; !equalLines(got, want) {
+		t.Errorf("tasks after undoing Restore: %q, want %q", got, want)
+	}
+}
+
+// failingStorage fails writes to one file, after letting the first few
+// succeed.
+type failingStorage struct {
+	*MemoryStorage
+	fn string
+	ok int
+}
+
+func (s *failingStorage) Write(fn string, data []byte) error {
+	if fn == s.fn {
+		if s.ok == 0 {
+			return errors.New("write failed")
+		}
+		s.ok--
+	}
+	return s.MemoryStorage.Write(fn, data)
+}
+
+func TestRestoreFailedWrite(t *testing.T) {
+	tf, s := newTestFile(t, "keep")
+	s.Write("trash.txt", []byte("gone deleted:2024-04-01\n"))
+	tf.Storage = &failingStorage{s, "todo.txt", 0}
+	if err := tf.Restore(SourceTrash, 0); err == nil {
+		t.Fatal("Restore succeeded with a failing write")
+	}
+	if got, want := originals(tf), []string{"keep"}; !equalLines(got, want) {
+		t.Errorf("tasks after a failed Restore: %q, want %q", got, want)
+	}
+	if got, want := fileLines(t, s, "trash.txt"), []string{"gone deleted:2024-04-01"}; !equalLines(got, want) {
+		t.Errorf("trash.txt after a failed Restore: %q, want %q", got, want)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	tf, s := newTestFile(t, "keep")
+	s.Write("trash.txt", []byte("old deleted:2024-03-01\nrecent deleted:2024-04-25\nundated\n"))
+	if _, err := tf.Purge(-1); err == nil {
+		t.Error("Purge(-1) succeeded")
+	}
+	if got := fileLines(t, s, "trash.txt"); len(got) != 3 {
+		t.Errorf("Purge(-1) left %q", got)
+	}
+	n, err := tf.Purge(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, s, "trash.txt"), []string{"recent deleted:2024-04-25", "undated"}; n != 1 || !equalLines(got, want) {
+		t.Errorf("Purge(30) purged %d, leaving %q, want 1 leaving %q", n, got, want)
+	}
+	if n, err = tf.Purge(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := fileLines(t, s, "trash.txt"); n != 2 || len(got) != 0 {
+		t.Errorf("Purge(0) purged %d, leaving %q", n, got)
+	}
+	if err := tf.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileLines(t, s, "trash.txt"), []string{"recent deleted:2024-04-25", "undated"}; !equalLines(got, want) {
+		t.Errorf("trash.txt after undoing Purge: %q, want %q", got, want)
+	}
+}
+
+func TestSideFileNames(t *testing.T) {
+	tests := []struct{ fn, done, trash string }{
+		{"todo.txt", "done.txt", "trash.txt"},
+		{"lists/todo.txt", "lists/done.txt", "lists/trash.txt"},
+		{"lists/work.txt", "lists/work_done.txt", "lists/work_trash.txt"},
+	}
+	for _, tt := range tests {
+		if got := relativeFileName(tt.fn, "done"); got != tt.done {
+			t.Errorf("done file of %s = %s, want %s", tt.fn, got, tt.done)
+		}
+		if got := relativeFileName(tt.fn, "trash"); got != tt.trash {
+			t.Errorf("trash file of %s = %s, want %s", tt.fn, got, tt.trash)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package tdt
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TokenKind is the part a token plays in a task.
+type TokenKind int
+
+const (
+	TokenWord       TokenKind = iota
+	TokenDone                 // the x of a completed task
+	TokenCompleted            // the completion date
+	TokenPriority             // (A)
+	TokenCreated              // the creation date
+	TokenProject              // +project
+	TokenContext              // @context
+	TokenTag                  // any key:value tag without its own kind
+	TokenDue                  // the due: tag that sets Task.Due
+	TokenThreshold            // the t: tag that sets Task.Threshold
+	TokenRecurrence           // the rec: tag that sets Task.Recurrence
+	TokenID                   // the id: tag that sets Task.ID
+	TokenPriTag               // the pri: tag that keeps a completed task's priority
+)
+
+// Strict makes tasks be read exactly as the todo.txt format describes: a
+// line starting with x is completed even without a completion date, a
+// priority after the completion date is just text, and projects and
+// contexts run to the next space rather than ending at punctuation.
+var Strict = false
+
+var (
+	dateTokenRegex     = regexp.MustCompile(`^\d{4}-\d\d-\d\d$`)
+	priorityTokenRegex = regexp.MustCompile(`^\(([A-Z])\)$`)
+	projectTokenRegex  = regexp.MustCompile(`^\+(\w+)`)
+	contextTokenRegex  = regexp.MustCompile(`^@(\w+)`)
+	strictProjectRegex = regexp.MustCompile(`^\+(\S+)`)
+	strictContextRegex = regexp.MustCompile(`^@(\S+)`)
+	tagTokenRegex      = regexp.MustCompile(`^([A-Za-z][\w-]*):([^:/]\S*)$`)
+	idValueRegex       = regexp.MustCompile(`^[\w-]+$`)
+	priValueRegex      = regexp.MustCompile(`^[A-Z]$`)
+)
+
+// Token is a word of a task line. Pos is the byte offset of Text in the
+// line. Value is the letter of a priority, the name of a project or
+// context, or the value of a tag, whose key is Key.
+type Token struct {
+	Kind  TokenKind `json:"kind"`
+	Text  string    `json:"text"`
+	Pos   int       `json:"pos"`
+	Key   string    `json:"key,omitempty"`
+	Value string    `json:"value,omitempty"`
+}
+
+// Tokens returns the words of the task's line, in order.
+func (t *Task) Tokens() []Token {
+	return append([]Token(nil), t.tokens...)
+}
+
+// tokenize splits a line into tokens. Tags are all TokenTag; parseTask
+// picks out the ones with a meaning of their own.
+func tokenize(line string) []Token {
+	var tokens []Token
+	start := -1
+	for i, r := range line + " " {
+		if !unicode.IsSpace(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, Token{Text: line[start:i], Pos: start})
+			start = -1
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	// A completed task starts with x, then come the priority, completion
+	// date and creation date, each if present. The priority may also
+	// follow the completion date, which is where Toggle used to put it.
+	is := func(i int, re *regexp.Regexp) bool {
+		return i < len(tokens) && tokens[i].Kind == TokenWord && re.MatchString(tokens[i].Text)
+	}
+	i := 0
+	if tokens[0].Text == "x" &&
+		(Strict || is(1, dateTokenRegex) || is(1, priorityTokenRegex) && is(2, dateTokenRegex)) {
+		tokens[0].Kind = TokenDone
+		i++
+	}
+	priority := func() {
+		if is(i, priorityTokenRegex) {
+			tokens[i].Kind = TokenPriority
+			tokens[i].Value = tokens[i].Text[1:2]
+			i++
+		}
+	}
+	priority()
+	if tokens[0].Kind == TokenDone && is(i, dateTokenRegex) {
+		tokens[i].Kind = TokenCompleted
+		i++
+		if !Strict && tokens[i-2].Kind == TokenDone {
+			priority()
+		}
+	}
+	if is(i, dateTokenRegex) {
+		tokens[i].Kind = TokenCreated
+		i++
+	}
+	projectRegex, contextRegex := projectTokenRegex, contextTokenRegex
+	if Strict {
+		projectRegex, contextRegex = strictProjectRegex, strictContextRegex
+	}
+	for ; i < len(tokens); i++ {
+		tok := &tokens[i]
+		if found := projectRegex.FindStringSubmatch(tok.Text); found != nil {
+			tok.Kind = TokenProject
+			tok.Value = found[1]
+		} else if found := contextRegex.FindStringSubmatch(tok.Text); found != nil {
+			tok.Kind = TokenContext
+			tok.Value = found[1]
+		} else if found := tagTokenRegex.FindStringSubmatch(tok.Text); found != nil {
+			tok.Kind = TokenTag
+			tok.Key = found[1]
+			tok.Value = found[2]
+		}
+	}
+	return tokens
+}
+
+// findToken returns the index of the task's first token of the given
+// kind, or -1.
+func (t *Task) findToken(kind TokenKind) int {
+	for i, tok := range t.tokens {
+		if tok.Kind == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+// The functions below change a task by editing its line, so that the
+// rest of the line, spacing and all, is kept as it was. The task is then
+// parsed again from the new line.
+
+// setToken replaces the text of the ith token.
+func (t *Task) setToken(i int, text string) {
+	tok := t.tokens[i]
+	t.reparse(t.original[:tok.Pos] + text + t.original[tok.Pos+len(tok.Text):])
+}
+
+// insertToken adds text before the ith token, or at the end of the line
+// if i is the number of tokens.
+func (t *Task) insertToken(i int, text string) {
+	switch {
+	case len(t.tokens) == 0:
+		t.reparse(text)
+	case i >= len(t.tokens):
+		last := t.tokens[len(t.tokens)-1]
+		end := last.Pos + len(last.Text)
+		t.reparse(t.original[:end] + " " + text + t.original[end:])
+	default:
+		pos := t.tokens[i].Pos
+		t.reparse(t.original[:pos] + text + " " + t.original[pos:])
+	}
+}
+
+// removeToken removes the ith token, along with the space before it, or
+// after it if it is the first.
+func (t *Task) removeToken(i int) {
+	tok := t.tokens[i]
+	start, end := tok.Pos, tok.Pos+len(tok.Text)
+	switch {
+	case i > 0:
+		prev := t.tokens[i-1]
+		start = prev.Pos + len(prev.Text)
+	case len(t.tokens) > 1:
+		end = t.tokens[1].Pos
+	}
+	t.reparse(t.original[:start] + t.original[end:])
+}
+
+// setTag sets the value of the tag of the given kind, adding it to the
+// end of the line if the task doesn't have one.
+func (t *Task) setTag(kind TokenKind, key, value string) {
+	if i := t.findToken(kind); i >= 0 {
+		t.setToken(i, key+":"+value)
+	} else {
+		t.insertToken(len(t.tokens), key+":"+value)
+	}
+}
+
+// setTagValue sets the value of the first plain tag with the given key,
+// ignoring case, adding the tag to the end of the line if there isn't one.
+func (t *Task) setTagValue(key, value string) {
+	if i := t.findTagKey(key); i >= 0 {
+		t.setToken(i, t.tokens[i].Key+":"+value)
+	} else {
+		t.insertToken(len(t.tokens), key+":"+value)
+	}
+}
+
+// removeTagValue removes the first plain tag with the given key, ignoring
+// case, if there is one.
+func (t *Task) removeTagValue(key string) {
+	if i := t.findTagKey(key); i >= 0 {
+		t.removeToken(i)
+	}
+}
+
+// findTagKey returns the index of the first plain tag with the given key,
+// ignoring case, or -1.
+func (t *Task) findTagKey(key string) int {
+	for i, tok := range t.tokens {
+		if tok.Kind == TokenTag && strings.EqualFold(tok.Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeTag removes the tag of the given kind, if there is one.
+func (t *Task) removeTag(kind TokenKind) {
+	if i := t.findToken(kind); i >= 0 {
+		t.removeToken(i)
+	}
+}
+
+// headerEnd returns the index of the first token after the ones of the
+// given kinds at the start of the line.
+func (t *Task) headerEnd(kinds ...TokenKind) int {
+	i := 0
+next:
+	for i < len(t.tokens) {
+		for _, kind := range kinds {
+			if t.tokens[i].Kind == kind {
+				i++
+				continue next
+			}
+		}
+		break
+	}
+	return i
+}
+
+// setPriority sets the priority letter, or removes the priority if p is
+// empty.
+func (t *Task) setPriority(p string) {
+	i := t.findToken(TokenPriority)
+	switch {
+	case i >= 0 && p == "":
+		t.removeToken(i)
+	case i >= 0:
+		t.setToken(i, "("+p+")")
+	case p != "":
+		t.insertToken(t.headerEnd(TokenDone, TokenCompleted), "("+p+")")
+	}
+}
+
+// setCreated sets the creation date, which follows the priority if there
+// is one.
+func (t *Task) setCreated(d time.Time) {
+	if i := t.findToken(TokenCreated); i >= 0 {
+		t.setToken(i, YMD(d))
+	} else {
+		t.insertToken(t.headerEnd(TokenDone, TokenCompleted, TokenPriority), YMD(d))
+	}
+}
+
+// complete marks the task as done on the given day. Its priority is
+// moved to a pri: tag, as the todo.txt format suggests, so that the line
+// starts with the x and the completion date.
+func (t *Task) complete(d time.Time) {
+	if t.IsDone() {
+		return
+	}
+	done := "x " + YMD(d)
+	// The completion date takes the place of a leading priority, keeping
+	// the spacing after it for uncomplete to put back
+	if i := t.findToken(TokenPriority); i == 0 {
+		p := t.tokens[i].Value
+		t.setToken(i, done)
+		t.setPriTag(p)
+		return
+	}
+	t.priorityToTag()
+	t.insertToken(0, done)
+}
+
+// uncomplete marks a completed task as not done, removing the x and the
+// completion date, and restoring the priority from its pri: tag.
+func (t *Task) uncomplete() {
+	p := ""
+	if i := t.findToken(TokenPriTag); i >= 0 {
+		p = t.tokens[i].Value
+		t.removeToken(i)
+	}
+	if p != "" && len(t.tokens) > 1 && t.tokens[0].Kind == TokenDone && t.tokens[1].Kind == TokenCompleted {
+		date := t.tokens[1]
+		t.reparse(t.original[:t.tokens[0].Pos] + "(" + p + ")" + t.original[date.Pos+len(date.Text):])
+		return
+	}
+	var b strings.Builder
+	last := 0
+	for i, tok := range t.tokens {
+		if tok.Kind != TokenDone && tok.Kind != TokenCompleted {
+			continue
+		}
+		end := len(t.original)
+		if i+1 < len(t.tokens) {
+			end = t.tokens[i+1].Pos
+		}
+		b.WriteString(t.original[last:tok.Pos])
+		last = end
+	}
+	if last > 0 {
+		b.WriteString(t.original[last:])
+		t.reparse(b.String())
+	}
+	if p != "" {
+		t.setPriority(p)
+	}
+}
+
+// priorityToTag moves the priority into a pri: tag, which is how the
+// todo.txt format keeps the priority of a completed task. A pri: tag
+// that is already there is given the priority.
+func (t *Task) priorityToTag() {
+	if i := t.findToken(TokenPriority); i >= 0 {
+		p := t.tokens[i].Value
+		t.removeToken(i)
+		t.setPriTag(p)
+	}
+}
+
+// setPriTag gives the pri: tag the priority p, adding the tag if there
+// isn't one. On a completed task the tag may already be read as the
+// priority rather than as a plain tag.
+func (t *Task) setPriTag(p string) {
+	if i := t.findToken(TokenPriTag); i >= 0 {
+		t.setToken(i, t.tokens[i].Key+":"+p)
+	} else {
+		t.setTagValue("pri", p)
+	}
+}
+
+// fixDates replaces relative due and threshold dates, such as due:fri,
+// with the dates they stand for today.
+func (t *Task) fixDates() {
+	// Both are replaced before the line is parsed again, as a relative
+	// date that is left would no longer be read as one
+	line := t.original
+	for i := len(t.tokens) - 1; i >= 0; i-- {
+		tok := t.tokens[i]
+		var text string
+		switch {
+		case tok.Kind == TokenDue && tok.Value != YMD(t.Due):
+			text = "due:" + YMD(t.Due)
+		case tok.Kind == TokenThreshold && tok.Value != YMD(t.Threshold):
+			text = "t:" + YMD(t.Threshold)
+		default:
+			continue
+		}
+		line = line[:tok.Pos] + text + line[tok.Pos+len(tok.Text):]
+	}
+	if line != t.original {
+		t.reparse(line)
+	}
+}
+
+// reparse replaces the task with one parsed from line, keeping its place
+// in the file. It leaves the task as it was if line can't be parsed.
+func (t *Task) reparse(line string) {
+	n, err := parseTask(line)
+	if err != nil {
+		Log(log.Warning, "not changing task:", err)
+		return
+	}
+	n.LineNumber = t.LineNumber
+	n.Deleted = t.Deleted
+	n.FilteredOut = t.FilteredOut
+	*t = n
+}
@@ -0,0 +1,114 @@
+package tdt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	line := "x 2024-05-02 (A)  2024-05-01 call +mom @phone  due:2024-05-03 url:http://x.y odd: ::"
+	want := []Token{
+		{Kind: TokenDone, Text: "x", Pos: 0},
+		{Kind: TokenCompleted, Text: "2024-05-02", Pos: 2},
+		{Kind: TokenPriority, Text: "(A)", Pos: 13, Value: "A"},
+		{Kind: TokenCreated, Text: "2024-05-01", Pos: 18},
+		{Kind: TokenWord, Text: "call", Pos: 29},
+		{Kind: TokenProject, Text: "+mom", Pos: 34, Value: "mom"},
+		{Kind: TokenContext, Text: "@phone", Pos: 39, Value: "phone"},
+		{Kind: TokenTag, Text: "due:2024-05-03", Pos: 47, Key: "due", Value: "2024-05-03"},
+		{Kind: TokenTag, Text: "url:http://x.y", Pos: 62, Key: "url", Value: "http://x.y"},
+		{Kind: TokenWord, Text: "odd:", Pos: 77},
+		{Kind: TokenWord, Text: "::", Pos: 82},
+	}
+	if got := tokenize(line); !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize(%q) =\n%+v\nwant\n%+v", line, got, want)
+	}
+	for _, tok := range want {
+		if line[tok.Pos:tok.Pos+len(tok.Text)] != tok.Text {
+			t.Errorf("token %q is not at %d", tok.Text, tok.Pos)
+		}
+	}
+
+	task, err := parseTask(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []TokenKind
+	for _, tok := range task.Tokens() {
+		kinds = append(kinds, tok.Kind)
+	}
+	if kinds[7] != TokenDue {
+		t.Errorf("due tag parsed as kind %d", kinds[7])
+	}
+}
+
+func TestCompleteWithPriTag(t *testing.T) {
+	for _, line := range []string{"(A) call mom pri:B", "(A) call mom pri:b"} {
+		task, err := parseTask(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		task.complete(date("2024-05-01"))
+		if got, want := task.original, "x 2024-05-01 call mom pri:A"; got != want {
+			t.Errorf("completing %q = %q, want %q", line, got, want)
+		}
+		if task.Priority != "A" {
+			t.Errorf("completing %q left priority %q, want A", line, task.Priority)
+		}
+	}
+}
+
+func TestTokenEdits(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	const line = "(A)  call   mom  due:2024-05-03   +family  weird::tag\t t:2024-05-02"
+	tests := []struct {
+		name string
+		edit func(t *Task)
+		want string
+	}{
+		{"priority", func(t *Task) { t.setPriority("B") },
+			"(B)  call   mom  due:2024-05-03   +family  weird::tag\t t:2024-05-02"},
+		{"no priority", func(t *Task) { t.setPriority("") },
+			"call   mom  due:2024-05-03   +family  weird::tag\t t:2024-05-02"},
+		{"due", func(t *Task) { t.setDue(date("2024-06-01")) },
+			"(A)  call   mom  due:2024-06-01   +family  weird::tag\t t:2024-05-02"},
+		{"no due", func(t *Task) { t.removeTag(TokenDue) },
+			"(A)  call   mom   +family  weird::tag\t t:2024-05-02"},
+		{"no threshold", func(t *Task) { t.removeTag(TokenThreshold) },
+			"(A)  call   mom  due:2024-05-03   +family  weird::tag"},
+		{"created", func(t *Task) { t.setCreated(date("2024-04-01")) },
+			"(A)  2024-04-01 call   mom  due:2024-05-03   +family  weird::tag\t t:2024-05-02"},
+		{"id", func(t *Task) { setID(t, "abc") },
+			"(A)  call   mom  due:2024-05-03   +family  weird::tag\t t:2024-05-02 id:abc"},
+		{"complete", func(t *Task) { t.complete(date("2024-05-01")) },
+			"x 2024-05-01  call   mom  due:2024-05-03   +family  weird::tag\t t:2024-05-02 pri:A"},
+		{"complete and back", func(t *Task) { t.complete(date("2024-05-01")); t.uncomplete() }, line},
+	}
+	for _, tt := range tests {
+		task, err := parseTask(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.edit(&task)
+		if task.original != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, task.original, tt.want)
+		}
+		if again, _ := parseTask(task.original); again.Description != task.Description {
+			t.Errorf("%s: fields not updated: %q", tt.name, task.Description)
+		}
+	}
+}
+
+func TestToggleKeepsLine(t *testing.T) {
+	fixClock(t, "2024-05-01")
+	const line = "(B)  2024-04-01   spaced    out  +proj  due:2024-05-09"
+	tf, _ := newTestFile(t, line)
+	tf.Toggle(false, 0)
+	if got, want := tf.Tasks[0].original, "x 2024-05-01  2024-04-01   spaced    out  +proj  due:2024-05-09 pri:B"; got != want {
+		t.Errorf("completed: %q, want %q", got, want)
+	}
+	tf.Toggle(false, 0)
+	if got := tf.Tasks[0].original; got != line {
+		t.Errorf("completed and reopened: %q, want %q", got, line)
+	}
+}
@@ -4,23 +4,40 @@ import "time"
 
 type TaskFile struct {
 	Path       string
+	Storage    Storage
+	DoneFile   *TaskFile
+	TrashFile  *TaskFile
 	Opts       Opts
 	Tasks      Tasks
 	SortOrder  string
 	LastUpdate time.Time
 	Events     chan FileChangedEvent
+	base       []baseLine
+	history    []Operation
+	journal    *journal
+	locked     bool
 }
 
 type FileChangedEvent struct {
 	EventName string
+	Err       error
 }
 
 type Opts struct {
 	ShowFuture bool
 	SortOrder  string
+	AutoID     bool
+	Query      *Query
+	// GroupBy is one of project, context, priority or due, or empty.
+	GroupBy        string
+	GroupFirstOnly bool
+	// Include names the files, SourceDone or SourceTrash, whose tasks
+	// WithIncluded lists along with the file's own.
+	Include []string
 }
 
 type Task struct {
+	ID           string     `json:"id,omitempty"`
 	Done         int        `json:"done,omitempty"`
 	Priority     string     `json:"priority,omitempty"`
 	Completed    time.Time  `json:"completed,omitempty"`
@@ -31,20 +48,45 @@ type Task struct {
 	Due          time.Time  `json:"due,omitempty"`
 	HasDue       bool       `json:"has_due,omitempty"`
 	Overdue      bool       `json:"overdue,omitempty"`
+	DueToday     bool       `json:"due_today,omitempty"`
 	Threshold    time.Time  `json:"threshold,omitempty"`
 	HasThreshold bool       `json:"has_threshold,omitempty"`
 	Recurrence   Recurrence `json:"recurrence,omitempty"`
+	Tags         Tags       `json:"tags,omitempty"`
 	original     string     // no tag, see TaskJSON
-	LineNumber   int        `json:"line_number,omitempty"`
-	Deleted      bool       `json:"deleted,omitempty"`
-	FilteredOut  bool       `json:"filtered_out,omitempty"`
+	tokens       []Token
+	LineNumber   int  `json:"line_number,omitempty"`
+	Deleted      bool `json:"deleted,omitempty"`
+	FilteredOut  bool `json:"filtered_out,omitempty"`
+	// Source is SourceDone or SourceTrash for a task read from the done or
+	// trash file, whose LineNumber is then its line in that file.
+	Source string `json:"source,omitempty"`
 }
 
 type Tasks []Task
 
+// Tag is a key:value pair in a task other than the ones with their own
+// fields, such as due: and t:.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Tags are kept in the order they appear in the task.
+type Tags []Tag
+
 type Recurrence struct {
-	Period string `json:"period"`
-	Every  int64  `json:"every,omitempty"`
-	Strict bool   `json:"strict,omitempty"`
-	String string `json:"string,omitempty"`
+	// Period is d, w, m, y, b (business days), eom (end of month),
+	// weekday or nth (the Nth of Weekdays in the month).
+	Period   string         `json:"period"`
+	Every    int64          `json:"every,omitempty"`
+	Strict   bool           `json:"strict,omitempty"`
+	String   string         `json:"string,omitempty"`
+	Weekdays []time.Weekday `json:"weekdays,omitempty"`
+	Nth      int            `json:"nth,omitempty"`
+	// Until and Count come from the until: and count: tags. Until is a
+	// date after which the task no longer recurs; Count is the number of
+	// instances left, including this one.
+	Until string `json:"until,omitempty"`
+	Count int    `json:"count,omitempty"`
 }
@@ -0,0 +1,235 @@
+package tdt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+// WebdavStorage keeps task files on a WebDAV server. A copy of each file
+// is downloaded into TmpDir while it is being read or written. Locks are
+// held by creating fn.lock on the server, as not all servers support
+// WebDAV's own LOCK method.
+type WebdavStorage struct {
+	Url         string
+	User        string
+	Password    string
+	TmpDir      string
+	LockTimeout time.Duration
+	client      *gowebdav.Client
+	locks       lockOwners
+	// clockOffset is how far the server's clock is ahead of ours, once
+	// clockKnown is set
+	clockOffset time.Duration
+	clockKnown  bool
+}
+
+func NewWebdavStorage(url, user, password, tmpDir string) *WebdavStorage {
+	return &WebdavStorage{
+		Url:      url,
+		User:     user,
+		Password: password,
+		TmpDir:   tmpDir,
+		client:   gowebdav.NewClient(url, user, password),
+	}
+}
+
+func (s *WebdavStorage) Read(fn string) ([]byte, time.Time, error) {
+	tmp, modTime, err := s.download(fn)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return nil, time.Time{}, newFileError("read", tmp, err)
+	}
+	return data, modTime, nil
+}
+
+func (s *WebdavStorage) Write(fn string, data []byte) error {
+	tmp := s.tmpFile(fn)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return newFileError("write", tmp, err)
+	}
+	return s.upload(tmp, fn)
+}
+
+func (s *WebdavStorage) Append(fn string, data []byte) error {
+	tmp, _, err := s.download(fn)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	existing, err := os.ReadFile(tmp)
+	if err != nil && !os.IsNotExist(err) {
+		return newFileError("append", tmp, err)
+	}
+	return s.Write(fn, append(existing, data...))
+}
+
+func (s *WebdavStorage) Stat(fn string) (time.Time, error) {
+	info, err := s.client.Stat(fn)
+	if err != nil {
+		return time.Time{}, newDavError("stat", fn, err)
+	}
+	return info.ModTime(), nil
+}
+
+func (s *WebdavStorage) Watch(fn string, changed chan<- FileChangedEvent, stop <-chan struct{}) error {
+	updatedAt, err := s.Stat(fn)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			Log(log.Warning, "stop watching "+fn)
+			return nil
+		case <-ticker.C:
+			Log(log.Warning, "checking "+fn)
+			modTime, err := s.Stat(fn)
+			if err != nil {
+				return err
+			}
+			if modTime.After(updatedAt) {
+				Log(log.Warning, "modified "+fn)
+				updatedAt = modTime
+				select {
+				case changed <- FileChangedEvent{EventName: "WebDAV"}:
+				case <-stop:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (s *WebdavStorage) Lock(fn string) error {
+	name := lockName(fn)
+	owner := lockOwner()
+	return waitForLock(fn, s.LockTimeout, func() (bool, error) {
+		info, err := s.client.Stat(name)
+		if err != nil && !gowebdav.IsErrNotFound(err) {
+			return false, newDavError("lock", name, err)
+		}
+		if err == nil {
+			now, err := s.serverNow(name)
+			if err != nil {
+				return false, err
+			}
+			if now.Sub(info.ModTime()) < staleLockAge {
+				return false, nil
+			}
+		}
+		err = s.client.Write(name, []byte(owner+"\n"), 0644)
+		if err != nil {
+			return false, newDavError("lock", name, err)
+		}
+		// Another process may have written its lock at the same time; the
+		// last one written wins.
+		data, err := s.client.Read(name)
+		if err != nil {
+			return false, newDavError("lock", name, err)
+		}
+		if strings.TrimSpace(string(data)) != owner {
+			return false, nil
+		}
+		s.locks.set(fn, owner)
+		return true, nil
+	})
+}
+
+// serverNow returns the time on the server, so that the age of a lock on
+// it can be judged however far apart the two clocks are. The server's
+// clock is found once, from the modification time of a probe file
+// written next to name.
+func (s *WebdavStorage) serverNow(name string) (time.Time, error) {
+	if !s.clockKnown {
+		probe := fmt.Sprintf("%s.%d.now", name, time.Now().UnixNano())
+		before := time.Now()
+		if err := s.client.Write(probe, nil, 0644); err != nil {
+			return time.Time{}, newDavError("lock", probe, err)
+		}
+		info, err := s.client.Stat(probe)
+		after := time.Now()
+		s.client.Remove(probe)
+		if err != nil {
+			return time.Time{}, newDavError("lock", probe, err)
+		}
+		local := before.Add(after.Sub(before) / 2)
+		s.clockOffset, s.clockKnown = info.ModTime().Sub(local), true
+	}
+	return time.Now().Add(s.clockOffset), nil
+}
+
+func (s *WebdavStorage) Unlock(fn string) error {
+	name := lockName(fn)
+	owner := s.locks.take(fn)
+	data, err := s.client.Read(name)
+	if gowebdav.IsErrNotFound(err) {
+		return nil
+	} else if err != nil {
+		return newDavError("unlock", name, err)
+	}
+	if strings.TrimSpace(string(data)) != owner {
+		// the lock was taken over as stale
+		return nil
+	}
+	return newDavError("unlock", name, s.client.Remove(name))
+}
+
+// tmpFile returns where fn is kept while it is read or written. The name
+// includes a hash of the server and path, so that files with the same
+// name elsewhere don't share it.
+func (s *WebdavStorage) tmpFile(fn string) string {
+	sum := sha256.Sum256([]byte(s.Url + "\x00" + fn))
+	return path.Join(s.TmpDir, fmt.Sprintf("__%x_%s", sum[:6], path.Base(fn)))
+}
+
+// download copies fn into the temp directory. If fn does not exist on the
+// server the temp file is left empty and ErrNotFound is returned.
+func (s *WebdavStorage) download(fn string) (string, time.Time, error) {
+	tmp := s.tmpFile(fn)
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", time.Time{}, newFileError("download", tmp, err)
+	}
+	defer file.Close()
+
+	// The time is taken first, so that if fn changes while it is read the
+	// change is still seen later, rather than missed
+	modTime, err := s.Stat(fn)
+	if err != nil {
+		return tmp, time.Time{}, err
+	}
+
+	reader, err := s.client.ReadStream(fn)
+	if err != nil {
+		return tmp, time.Time{}, newDavError("download", fn, err)
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return "", time.Time{}, newDavError("download", fn, err)
+	}
+
+	return tmp, modTime, nil
+}
+
+func (s *WebdavStorage) upload(tmp, fn string) error {
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return newFileError("upload", tmp, err)
+	}
+	err = s.client.WriteStream(fn, bytes.NewReader(data), 0644)
+	return newDavError("upload", fn, err)
+}